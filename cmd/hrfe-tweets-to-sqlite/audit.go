@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// entityRe matches an HTML entity.
+var entityRe = regexp.MustCompile(`&(?:#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z]+);`)
+
+// mojibake are sequences left when UTF-8 text is decoded as Latin-1 or
+// Windows-1252 along the way, as in "â€™" for "’" or "Ã©" for "é".
+var mojibake = []string{"â€", "Ã", "Â"}
+
+// textIssues returns what looks wrong with the encoding of tweet text s, if
+// anything. The text is stored as the API returned it, entities and all, so
+// entities only count if some are left after unescaping once.
+func textIssues(s string) []string {
+	var issues []string
+	if !utf8.ValidString(s) {
+		issues = append(issues, "invalid UTF-8")
+	} else if strings.ContainsRune(s, utf8.RuneError) {
+		issues = append(issues, "replacement character")
+	}
+	for _, m := range mojibake {
+		if strings.Contains(s, m) {
+			issues = append(issues, "mojibake "+strconv.Quote(m))
+			break
+		}
+	}
+	if e := entityRe.FindString(html.UnescapeString(s)); e != "" {
+		issues = append(issues, "double-escaped entity "+e)
+	}
+	return issues
+}
+
+// checkEncoding writes the tweet IDs of stored incidents whose text has
+// encoding problems, with what they are, so they can be reparsed or fetched
+// again. It returns how many were found and changes nothing.
+func checkEncoding(w io.Writer, db *sql.DB, asCSV bool) (int, error) {
+	rows, err := db.Query("select tweet_id, tweet_text from incidents order by tweet_id")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	table := [][]string{{"tweet_id", "issues"}}
+	for rows.Next() {
+		var (
+			tweetID int64
+			text    string
+		)
+		if err := rows.Scan(&tweetID, &text); err != nil {
+			return 0, err
+		}
+		if issues := textIssues(text); len(issues) > 0 {
+			table = append(table, []string{strconv.FormatInt(tweetID, 10), strings.Join(issues, ", ")})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return len(table) - 1, writeTable(w, table, asCSV)
+}
+
+// orphanChecks count rows in tables hanging off incidents that no longer
+// have an incident.
+var orphanChecks = []struct{ table, query string }{
+	{"incident_media", "select count(*) from incident_media where tweet_id not in (select tweet_id from incidents)"},
+	{"incident_alternate_tweets", "select count(*) from incident_alternate_tweets where tweet_id not in (select tweet_id from incidents)"},
+	{"incident_communities", "select count(*) from incident_communities where tweet_id not in (select tweet_id from incidents)"},
+	{"incident_tags", "select count(*) from incident_tags where tweet_id not in (select tweet_id from incidents)"},
+}
+
+// checkIntegrity runs SQLite's integrity and foreign key checks on db and
+// looks for orphaned rows, writing what it finds to w. It returns whether
+// everything was fine.
+func checkIntegrity(w io.Writer, db *sql.DB) (bool, error) {
+	ok := true
+
+	rows, err := db.Query("pragma integrity_check")
+	if err != nil {
+		return false, err
+	}
+	var results []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			rows.Close()
+			return false, err
+		}
+		results = append(results, s)
+	}
+	if err := rows.Close(); err != nil {
+		return false, err
+	}
+	if len(results) == 1 && results[0] == "ok" {
+		fmt.Fprintln(w, "integrity: ok")
+	} else {
+		ok = false
+		for _, r := range results {
+			fmt.Fprintf(w, "integrity: %s\n", r)
+		}
+	}
+
+	var fkProblems int
+	if err := db.QueryRow("select count(*) from pragma_foreign_key_check").Scan(&fkProblems); err != nil {
+		return false, err
+	}
+	if fkProblems > 0 {
+		ok = false
+		fmt.Fprintf(w, "foreign keys: %d violations\n", fkProblems)
+	} else {
+		fmt.Fprintln(w, "foreign keys: ok")
+	}
+
+	for _, c := range orphanChecks {
+		var n int
+		if err := db.QueryRow(c.query).Scan(&n); err != nil {
+			return false, err
+		}
+		if n > 0 {
+			ok = false
+			fmt.Fprintf(w, "%s: %d orphaned rows\n", c.table, n)
+		}
+	}
+	return ok, nil
+}
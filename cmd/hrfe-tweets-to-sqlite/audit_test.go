@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCheckEncoding(t *testing.T) {
+	db := newTestDB(t)
+	for i, text := range []string{
+		"22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1",
+		// As the API returns it, escaped once.
+		"22-2\nPORTLAND ST &amp; PLEASANT ST  DARTMOUTH\nMVC\nE12",
+		"22-3\nPORTLAND ST &amp;amp; PLEASANT ST  DARTMOUTH\nMVC\nE12",
+		"22-4\n1 RUE L\u00c3\u00a9VESQUE  DARTMOUTH\nFIRE\nE1",
+		"22-5\n1 MAIN ST  DARTMOUTH\nFIRE\xff\nE1",
+		"22-6\n1 MAIN ST  DARTMOUTH\nFIRE \ufffd\nE1",
+		"22-7\n1 CAF\u00c9 ST  DARTMOUTH\nFIRE\nE1",
+	} {
+		in := Incident{ID: "22-1", Location: "1 MAIN ST", Type: "FIRE", TweetID: int64(i + 1), TweetText: text, CreatedAt: testTime}
+		if _, err := insertIncident(db, in); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	n, err := checkEncoding(&out, db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `tweet_id,issues
+3,double-escaped entity &amp;
+4,"mojibake ""Ã"""
+5,invalid UTF-8
+6,replacement character
+`
+	if got := out.String(); n != 4 || got != want {
+		t.Errorf("found %d:\n%s\nwant 4:\n%s", n, got, want)
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(1, "22-1\n1 MAIN ST  DARTMOUTH/COLE HARBOUR\nFIRE\nE1"),
+		testTweet(2, "22-2\n2 MAIN ST  DARTMOUTH\nFIRE\nE1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	ok, err := checkIntegrity(&out, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "integrity: ok\nforeign keys: ok\n"; !ok || out.String() != want {
+		t.Errorf("got %v:\n%s\nwant true:\n%s", ok, out.String(), want)
+	}
+
+	if _, err := db.Exec("insert into incident_tags values (99, 'fire')"); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	ok, err = checkIntegrity(&out, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "incident_tags: 1 orphaned rows\n"; ok || !strings.HasSuffix(out.String(), want) {
+		t.Errorf("got %v:\n%s\nwant false ending %q", ok, out.String(), want)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/dghubble/oauth1"
+)
+
+// httpClient returns a client authenticated with whichever Twitter
+// credentials are in the environment: an app-only OAuth2 bearer token in
+// TWITTER_BEARER_TOKEN, or OAuth1 user context credentials in the other
+// TWITTER_* variables. Exactly one of the two must be configured.
+func httpClient() (*http.Client, error) {
+	bearer := os.Getenv("TWITTER_BEARER_TOKEN")
+	consumerKey, consumerSecret := os.Getenv("TWITTER_CONSUMER_KEY"), os.Getenv("TWITTER_CONSUMER_SECRET")
+	appToken, appSecret := os.Getenv("TWITTER_APP_TOKEN"), os.Getenv("TWITTER_APP_SECRET")
+
+	var oauth1Set int
+	for _, v := range []string{consumerKey, consumerSecret, appToken, appSecret} {
+		if v != "" {
+			oauth1Set++
+		}
+	}
+
+	switch {
+	case bearer != "" && oauth1Set > 0:
+		return nil, errors.New("both TWITTER_BEARER_TOKEN and OAuth1 credentials are set, use only one")
+	case bearer != "":
+		return &http.Client{Transport: bearerTransport{token: bearer, base: http.DefaultTransport}}, nil
+	case oauth1Set == 4:
+		oaConfig := oauth1.NewConfig(consumerKey, consumerSecret)
+		oaToken := oauth1.NewToken(appToken, appSecret)
+		return oaConfig.Client(oauth1.NoContext, oaToken), nil
+	case oauth1Set > 0:
+		return nil, errors.New("OAuth1 needs all of TWITTER_CONSUMER_KEY, TWITTER_CONSUMER_SECRET, TWITTER_APP_TOKEN, and TWITTER_APP_SECRET")
+	default:
+		return nil, errors.New("no Twitter credentials, set TWITTER_BEARER_TOKEN or the OAuth1 TWITTER_* variables")
+	}
+}
+
+// bearerTransport adds an OAuth2 bearer token to each request.
+type bearerTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// The backfill table holds a single row while a backfill has stopped at
+// -backfill-pages before reaching the start of the timeline, recording the
+// oldest tweet ID it got to. The next run resumes it before fetching new
+// tweets.
+
+// markBackfillIncomplete records that a backfill stopped at minID.
+func markBackfillIncomplete(db *sql.DB, minID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("delete from backfill"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("insert into backfill values (?, datetime('now'))", minID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// clearBackfill records that a backfill reached the start of the timeline.
+func clearBackfill(db *sql.DB) error {
+	_, err := db.Exec("delete from backfill")
+	return err
+}
+
+// pendingBackfill returns the tweet ID an incomplete backfill stopped at, if
+// there is one.
+func pendingBackfill(db *sql.DB) (int64, bool, error) {
+	var minID int64
+	err := db.QueryRow("select min_tweet_id from backfill").Scan(&minID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return minID, true, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// useColor is whether per-tweet output is decorated with color and emoji
+// by incident type. Set from flags, and only ever on for a terminal.
+var useColor bool
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// typeStyles decorate incident types containing their word, first match
+// winning.
+var typeStyles = []struct {
+	word, emoji, color string // color is an ANSI SGR code
+}{
+	{"ALARM", "🚨", "35"},   // magenta; before FIRE, for FIRE ALARM
+	{"FIRE", "🔥", "31"},    // red
+	{"MEDICAL", "🚑", "36"}, // cyan
+	{"MVC", "🚗", "33"},     // yellow
+	{"VEHICLE", "🚗", "33"},
+	{"RESCUE", "🛟", "34"},  // blue
+	{"HAZMAT", "☣️", "32"}, // green
+	{"GAS", "☣️", "32"},
+}
+
+// styleByType returns s decorated for the terminal by incident type typ
+// when useColor is set, and s unchanged otherwise or when typ matches no
+// style.
+func styleByType(typ, s string) string {
+	if !useColor {
+		return s
+	}
+	upper := strings.ToUpper(typ)
+	for _, st := range typeStyles {
+		if strings.Contains(upper, st.word) {
+			return st.emoji + " \x1b[" + st.color + "m" + s + "\x1b[0m"
+		}
+	}
+	return s
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsTerminal(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if isTerminal(f) {
+		t.Error("a file is a terminal")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if isTerminal(w) {
+		t.Error("a pipe is a terminal")
+	}
+}
+
+func TestStyleByType(t *testing.T) {
+	old := useColor
+	t.Cleanup(func() { useColor = old })
+
+	useColor = false
+	for _, typ := range []string{"STRUCTURE FIRE", "MEDICAL", "PUBLIC SERVICE"} {
+		if got := styleByType(typ, "line"); got != "line" {
+			t.Errorf("without color, styleByType(%q) = %q, want it unchanged", typ, got)
+		}
+	}
+
+	useColor = true
+	for typ, want := range map[string]string{
+		"FIRE ALARM":     "🚨 \x1b[35mline\x1b[0m",
+		"structure fire": "🔥 \x1b[31mline\x1b[0m",
+		"PUBLIC SERVICE": "line",
+	} {
+		if got := styleByType(typ, "line"); got != want {
+			t.Errorf("styleByType(%q) = %q, want %q", typ, got, want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// builtinCommunityAliases maps alternate spellings of HRFE communities,
+// upper-cased, to their canonical names, with each canonical name mapping to
+// itself so every community HRFE tweets about is known. Names that could be
+// more than one community, like SACKVILLE for Lower, Middle or Upper
+// Sackville, are left alone rather than guessed at. A -communities file adds
+// to these.
+var builtinCommunityAliases = map[string]string{
+	"HALIFAX":                  "HALIFAX",
+	"DARTMOUTH":                "DARTMOUTH",
+	"BEDFORD":                  "BEDFORD",
+	"LOWER SACKVILLE":          "LOWER SACKVILLE",
+	"MIDDLE SACKVILLE":         "MIDDLE SACKVILLE",
+	"UPPER SACKVILLE":          "UPPER SACKVILLE",
+	"BEAVER BANK":              "BEAVER BANK",
+	"COLE HARBOUR":             "COLE HARBOUR",
+	"EASTERN PASSAGE":          "EASTERN PASSAGE",
+	"COW BAY":                  "COW BAY",
+	"WESTPHAL":                 "WESTPHAL",
+	"NORTH PRESTON":            "NORTH PRESTON",
+	"EAST PRESTON":             "EAST PRESTON",
+	"CHERRY BROOK":             "CHERRY BROOK",
+	"LAKE ECHO":                "LAKE ECHO",
+	"PORTERS LAKE":             "PORTERS LAKE",
+	"LAWRENCETOWN":             "LAWRENCETOWN",
+	"WEST CHEZZETCOOK":         "WEST CHEZZETCOOK",
+	"EAST CHEZZETCOOK":         "EAST CHEZZETCOOK",
+	"MUSQUODOBOIT HARBOUR":     "MUSQUODOBOIT HARBOUR",
+	"MIDDLE MUSQUODOBOIT":      "MIDDLE MUSQUODOBOIT",
+	"SHEET HARBOUR":            "SHEET HARBOUR",
+	"FALL RIVER":               "FALL RIVER",
+	"WAVERLEY":                 "WAVERLEY",
+	"WELLINGTON":               "WELLINGTON",
+	"WINDSOR JUNCTION":         "WINDSOR JUNCTION",
+	"HAMMONDS PLAINS":          "HAMMONDS PLAINS",
+	"LUCASVILLE":               "LUCASVILLE",
+	"TIMBERLEA":                "TIMBERLEA",
+	"BEECHVILLE":               "BEECHVILLE",
+	"LAKESIDE":                 "LAKESIDE",
+	"SPRYFIELD":                "SPRYFIELD",
+	"HERRING COVE":             "HERRING COVE",
+	"HARRIETSFIELD":            "HARRIETSFIELD",
+	"WILLIAMSWOOD":             "WILLIAMSWOOD",
+	"SAMBRO":                   "SAMBRO",
+	"KETCH HARBOUR":            "KETCH HARBOUR",
+	"PROSPECT":                 "PROSPECT",
+	"HATCHET LAKE":             "HATCHET LAKE",
+	"UPPER TANTALLON":          "UPPER TANTALLON",
+	"HEAD OF ST MARGARETS BAY": "HEAD OF ST MARGARETS BAY",
+	"GLEN HAVEN":               "GLEN HAVEN",
+	"BOUTILIERS POINT":         "BOUTILIERS POINT",
+	"HUBBARDS":                 "HUBBARDS",
+	"GOODWOOD":                 "GOODWOOD",
+
+	// Abbreviations and misspellings seen in tweets.
+	"BEAVERBANK":                "BEAVER BANK",
+	"COLE HARBOR":               "COLE HARBOUR",
+	"COLE HBR":                  "COLE HARBOUR",
+	"DART":                      "DARTMOUTH",
+	"DARTMOUTH NS":              "DARTMOUTH",
+	"E CHEZZETCOOK":             "EAST CHEZZETCOOK",
+	"E PASSAGE":                 "EASTERN PASSAGE",
+	"E PRESTON":                 "EAST PRESTON",
+	"HAMMONDS PLNS":             "HAMMONDS PLAINS",
+	"HEAD OF ST MARGARET'S BAY": "HEAD OF ST MARGARETS BAY",
+	"HEAD OF ST. MARGARETS BAY": "HEAD OF ST MARGARETS BAY",
+	"HFX":                       "HALIFAX",
+	"KETCH HBR":                 "KETCH HARBOUR",
+	"L SACKVILLE":               "LOWER SACKVILLE",
+	"LWR SACKVILLE":             "LOWER SACKVILLE",
+	"MID MUSQUODOBOIT":          "MIDDLE MUSQUODOBOIT",
+	"MID SACKVILLE":             "MIDDLE SACKVILLE",
+	"MUSQ HBR":                  "MUSQUODOBOIT HARBOUR",
+	"MUSQUODOBOIT HBR":          "MUSQUODOBOIT HARBOUR",
+	"N PRESTON":                 "NORTH PRESTON",
+	"SHEET HBR":                 "SHEET HARBOUR",
+	"UPR SACKVILLE":             "UPPER SACKVILLE",
+	"UPR TANTALLON":             "UPPER TANTALLON",
+	"W CHEZZETCOOK":             "WEST CHEZZETCOOK",
+}
+
+// communityAliases is the alias map used by normalizeCommunity.
+var communityAliases = builtinCommunityAliases
+
+// normalizeCommunity returns the canonical name for community c, or c
+// unchanged when it has no alias.
+func normalizeCommunity(c string) string {
+	if canon, ok := communityAliases[strings.ToUpper(c)]; ok {
+		return canon
+	}
+	return c
+}
+
+// knownCommunity reports whether c is a community in the alias map, either
+// as an alias or a canonical name. With no aliases loaded nothing is known,
+// so every community is given the benefit of the doubt.
+func knownCommunity(c string) bool {
+	if len(communityAliases) == 0 {
+		return true
+	}
+	c = strings.ToUpper(c)
+	if _, ok := communityAliases[c]; ok {
+		return true
+	}
+	for _, canon := range communityAliases {
+		if strings.ToUpper(canon) == c {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCommunities splits a community like "DARTMOUTH/COLE HARBOUR" into
+// its normalized parts. It returns nil unless there are several parts and
+// every one is a known community, which needs aliases to be loaded: a slash
+// could just as well be part of a single name.
+func splitCommunities(c string) []string {
+	parts := strings.Split(c, "/")
+	if len(parts) < 2 || len(communityAliases) == 0 {
+		return nil
+	}
+	comms := make([]string, len(parts))
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" || !knownCommunity(p) {
+			return nil
+		}
+		comms[i] = normalizeCommunity(p)
+	}
+	return comms
+}
+
+// communityMunicipalities maps communities, upper-cased, to the
+// municipality they're in, for coarsenCommunity. It's loaded from a
+// -municipalities file, in the same format as -communities.
+var communityMunicipalities = map[string]string{}
+
+// coarsenCommunity returns the municipality community c is in, for
+// publishing incidents without pinpointing neighbourhoods. A community with
+// no known municipality becomes empty rather than leaking through.
+func coarsenCommunity(c string) string {
+	return communityMunicipalities[strings.ToUpper(c)]
+}
+
+// withBuiltinAliases returns the built-in aliases with aliases added,
+// aliases winning where both have the same one.
+func withBuiltinAliases(aliases map[string]string) map[string]string {
+	all := make(map[string]string, len(builtinCommunityAliases)+len(aliases))
+	for alias, canon := range builtinCommunityAliases {
+		all[alias] = canon
+	}
+	for alias, canon := range aliases {
+		all[alias] = canon
+	}
+	return all
+}
+
+// loadCommunityAliases reads a CSV file of alias,canonical pairs. Lines
+// starting with # are ignored. All bad lines are reported together.
+func loadCommunityAliases(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	aliases := make(map[string]string)
+	var bad []string
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		if len(rec) != 2 || strings.TrimSpace(rec[0]) == "" || strings.TrimSpace(rec[1]) == "" {
+			bad = append(bad, fmt.Sprintf("line %d: want alias,canonical", line))
+			continue
+		}
+		aliases[strings.ToUpper(strings.TrimSpace(rec[0]))] = strings.TrimSpace(rec[1])
+	}
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("%s: %s", path, strings.Join(bad, "; "))
+	}
+	return aliases, nil
+}
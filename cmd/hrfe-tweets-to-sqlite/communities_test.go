@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuiltinCommunityAliases(t *testing.T) {
+	for alias, want := range map[string]string{
+		"Lwr Sackville": "LOWER SACKVILLE",
+		"COLE HBR":      "COLE HARBOUR",
+		"DARTMOUTH":     "DARTMOUTH",
+		"ATLANTIS":      "ATLANTIS",
+		// Could be Lower, Middle or Upper Sackville.
+		"SACKVILLE": "SACKVILLE",
+	} {
+		if got := normalizeCommunity(alias); got != want {
+			t.Errorf("normalizeCommunity(%q) = %q, want %q", alias, got, want)
+		}
+	}
+
+	if !knownCommunity("hammonds plains") {
+		t.Error("HAMMONDS PLAINS isn't known")
+	}
+	if knownCommunity("PARK LANE") {
+		t.Error("PARK LANE is known")
+	}
+
+	if got, want := splitCommunities("DARTMOUTH/COLE HBR"), []string{"DARTMOUTH", "COLE HARBOUR"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("splitCommunities = %q, want %q", got, want)
+	}
+	if got := splitCommunities("DARTMOUTH/PARK LANE"); got != nil {
+		t.Errorf("splitCommunities with an unknown part = %q, want nil", got)
+	}
+}
+
+func TestParseStreetNotCommunity(t *testing.T) {
+	// A stray double space leaves what's plainly a street where the
+	// community would be.
+	in, err := parse("22-1\n1 MAIN ST  BACK LANE\nFIRE\nE1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Location != "1 MAIN ST BACK LANE" || in.Community != "" {
+		t.Errorf("location %q, community %q", in.Location, in.Community)
+	}
+}
+
+func TestLoadCommunityAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "communities.csv")
+	csv := "# alias,canonical\nsackville, LOWER SACKVILLE\nCOLE HBR,COLE HARBOUR EAST\nCOLE HARBOUR EAST,COLE HARBOUR EAST\n"
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := loadCommunityAliases(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := communityAliases
+	communityAliases = withBuiltinAliases(aliases)
+	defer func() { communityAliases = old }()
+
+	for alias, want := range map[string]string{
+		"Sackville":     "LOWER SACKVILLE",
+		"COLE HBR":      "COLE HARBOUR EAST",
+		"Lwr Sackville": "LOWER SACKVILLE",
+		"DARTMOUTH":     "DARTMOUTH",
+	} {
+		if got := normalizeCommunity(alias); got != want {
+			t.Errorf("normalizeCommunity(%q) = %q, want %q", alias, got, want)
+		}
+	}
+	if got, want := splitCommunities("DARTMOUTH/COLE HARBOUR EAST"), []string{"DARTMOUTH", "COLE HARBOUR EAST"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("splitCommunities = %q, want %q", got, want)
+	}
+	if builtinCommunityAliases["COLE HBR"] != "COLE HARBOUR" {
+		t.Error("loading a file changed the built-in aliases")
+	}
+
+	if err := os.WriteFile(path, []byte("DARTMOUTH\n,HALIFAX\nok,OK\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCommunityAliases(path); err == nil {
+		t.Error("loaded bad aliases, want an error")
+	}
+}
+
+func TestCoarsenCommunity(t *testing.T) {
+	old := communityMunicipalities
+	defer func() { communityMunicipalities = old }()
+	communityMunicipalities = map[string]string{
+		"DARTMOUTH":    "HALIFAX REGIONAL MUNICIPALITY",
+		"COLE HARBOUR": "HALIFAX REGIONAL MUNICIPALITY",
+	}
+
+	for c, want := range map[string]string{
+		"DARTMOUTH":    "HALIFAX REGIONAL MUNICIPALITY",
+		"Cole Harbour": "HALIFAX REGIONAL MUNICIPALITY",
+		// Unknown ones don't leak through.
+		"PARK LANE": "",
+		"":          "",
+	} {
+		if got := coarsenCommunity(c); got != want {
+			t.Errorf("coarsenCommunity(%q) = %q, want %q", c, got, want)
+		}
+	}
+
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH/COLE HARBOUR\nFIRE\nE1"),
+		testTweet(11, "22-2\n2 ELM ST  ATLANTIS\nFIRE\nE1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := (exportOptions{redactCommunity: redactCoarsen}).each(db, func(in Incident) error {
+		if in.Communities != nil {
+			t.Errorf("tweet id=%v kept communities %q", in.TweetID, in.Communities)
+		}
+		got = append(got, in.Community)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"HALIFAX REGIONAL MUNICIPALITY", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("exported communities %q, want %q", got, want)
+	}
+}
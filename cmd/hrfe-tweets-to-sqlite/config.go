@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"strings"
+)
+
+// credentialEnv lists the environment variables holding Twitter credentials.
+var credentialEnv = []string{
+	"TWITTER_CONSUMER_KEY",
+	"TWITTER_CONSUMER_SECRET",
+	"TWITTER_APP_TOKEN",
+	"TWITTER_APP_SECRET",
+	"TWITTER_BEARER_TOKEN",
+}
+
+// printConfig writes the effective settings to w as JSON, with credential
+// values masked.
+func printConfig(w io.Writer, dbPath, account string) error {
+	flags := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+
+	creds := make(map[string]string)
+	for _, k := range credentialEnv {
+		creds[k] = maskSecret(os.Getenv(k))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		DB          string            `json:"db"`
+		Account     string            `json:"account"`
+		Flags       map[string]string `json:"flags"`
+		Credentials map[string]string `json:"credentials"`
+	}{dbPath, account, flags, creds})
+}
+
+// maskSecret hides all but the last 4 characters of s. Short values are
+// hidden entirely and unset ones are left empty.
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return strings.Repeat("*", len(s)-4) + s[len(s)-4:]
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// expandDBPath expands ${VAR} and $VAR references in the -db path from the
+// environment. Referencing an unset variable is an error, since silently
+// dropping it would put the database somewhere unexpected.
+func expandDBPath(path string) (string, error) {
+	var unset []string
+	expanded := os.Expand(path, func(name string) string {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			unset = append(unset, name)
+		}
+		return v
+	})
+	if len(unset) > 0 {
+		return "", fmt.Errorf("%s: unset environment variables %s", path, strings.Join(unset, ", "))
+	}
+	return expanded, nil
+}
+
+// checkDBDir makes sure the directory holding the database at path exists,
+// creating it if mkdir is set.
+func checkDBDir(path string, mkdir bool) error {
+	dir := filepath.Dir(path)
+	if mkdir {
+		return os.MkdirAll(dir, 0o755)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+func initDB(db *sql.DB) error {
+	if _, err := db.Exec("create table if not exists incidents (id text, location text, community text, type text, apparatuses text, station text, created_at datetime, tweet_id integer UNIQUE, tweet_text text, tweet_created_at datetime)"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("create index if not exists incidents_created_at on incidents (created_at, tweet_id)"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("create table if not exists incident_media (incident_id text, tweet_id integer, media_id integer, type text, url text, UNIQUE (tweet_id, media_id))"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("create table if not exists processing_errors (tweet_id integer, class text, message text, tweet_text text, tweet_created_at text, recorded_at datetime)"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("create table if not exists incident_events (tweet_id integer, incident_id text, seen_at datetime, outcome text, changed boolean)"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("create table if not exists incident_alternate_tweets (tweet_id integer, alternate_tweet_id integer UNIQUE)"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("create table if not exists incident_communities (tweet_id integer, community text, UNIQUE (tweet_id, community))"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("create table if not exists incident_tags (tweet_id integer, tag text, UNIQUE (tweet_id, tag))"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("create table if not exists runs (ran_at datetime, max_tweet_id integer)"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("create table if not exists backfill (min_tweet_id integer, updated_at datetime)"); err != nil {
+		return err
+	}
+	return migrate(db)
+}
+
+// migrate brings the tables created by earlier versions up to date. Each
+// step must be safe to run against an already migrated database.
+func migrate(db *sql.DB) error {
+	added, err := addColumn(db, "incidents", "apparatus_count", "integer")
+	if err != nil {
+		return err
+	}
+	if added {
+		if _, err := db.Exec("update incidents set apparatus_count = " + wordCount("apparatuses")); err != nil {
+			return err
+		}
+	}
+	added, err = addColumn(db, "incidents", "station_count", "integer")
+	if err != nil {
+		return err
+	}
+	if added {
+		if _, err := db.Exec("update incidents set station_count = " + wordCount("station")); err != nil {
+			return err
+		}
+	}
+	if _, err := addColumn(db, "incidents", "type_inferred", "boolean not null default false"); err != nil {
+		return err
+	}
+	// Rows from before parser_version was stored are left null and treated
+	// as version 0.
+	if _, err := addColumn(db, "incidents", "parser_version", "integer"); err != nil {
+		return err
+	}
+	if _, err := addColumn(db, "incidents", "tweet_text_normalized", "text"); err != nil {
+		return err
+	}
+	if _, err := addColumn(db, "incidents", "disposition", "text not null default ''"); err != nil {
+		return err
+	}
+	if _, err := addColumn(db, "incidents", "dispatched_at", "datetime"); err != nil {
+		return err
+	}
+	if _, err := addColumn(db, "incidents", "is_test", "boolean not null default false"); err != nil {
+		return err
+	}
+	if _, err := addColumn(db, "incidents", "tweet_lang", "text not null default ''"); err != nil {
+		return err
+	}
+	if _, err := addColumn(db, "incidents", "tweet_source", "text not null default ''"); err != nil {
+		return err
+	}
+	// incidents_by_id collapses the tweets for each incident into the first
+	// one, with apparatus and stations merged from all of them. Readers
+	// should split and de-duplicate the merged lists. Its columns match
+	// incidentColumns, so it's recreated in case they've changed.
+	if _, err := db.Exec("drop view if exists incidents_by_id"); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`create view incidents_by_id as
+		select id, location, community, type,
+			group_concat(apparatuses, ' ') as apparatuses,
+			group_concat(station, ' ') as station,
+			created_at, min(tweet_id) as tweet_id, tweet_text, type_inferred, disposition, dispatched_at, is_test, tweet_lang, tweet_source, parser_version
+		from incidents group by id`); err != nil {
+		return err
+	}
+	if _, err := db.Exec("create index if not exists incidents_apparatus_count on incidents (apparatus_count)"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// wordCount returns an SQL expression counting the words in a column holding
+// a single-space-joined list.
+func wordCount(col string) string {
+	return fmt.Sprintf("case when coalesce(%[1]s, '') = '' then 0 else length(%[1]s) - length(replace(%[1]s, ' ', '')) + 1 end", col)
+}
+
+// addColumn adds a column to table unless it already exists, reporting
+// whether it did.
+func addColumn(db *sql.DB, table, column, typ string) (bool, error) {
+	var n int
+	if err := db.QueryRow("select count(*) from pragma_table_info(?) where name = ?", table, column).Scan(&n); err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+	if _, err := db.Exec(fmt.Sprintf("alter table %s add column %s %s", table, column, typ)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Retrying writes that hit another connection's lock.
+const (
+	busyRetries = 5
+	busyBackoff = 50 * time.Millisecond // doubled after each retry
+)
+
+// isBusy reports whether err is SQLite saying the database is busy or
+// locked, which may clear up if the operation is tried again.
+func isBusy(err error) bool {
+	var se *sqlite.Error
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.Code() & 0xff { // primary result code, without extended bits
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}
+
+// retryBusy calls fn, calling it again with backoff for as long as it fails
+// with a busy error, up to busyRetries times. Other errors are returned
+// immediately.
+func retryBusy(fn func() error) error {
+	backoff := busyBackoff
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i == busyRetries || !isBusy(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// tableColumns returns the declared type of each column of every table in
+// db, by table and column name.
+func tableColumns(db *sql.DB) (map[string]map[string]string, error) {
+	rows, err := db.Query("select m.name, c.name, c.type from sqlite_master m join pragma_table_info(m.name) c where m.type = 'table'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tables := make(map[string]map[string]string)
+	for rows.Next() {
+		var table, column, typ string
+		if err := rows.Scan(&table, &column, &typ); err != nil {
+			return nil, err
+		}
+		if tables[table] == nil {
+			tables[table] = make(map[string]string)
+		}
+		tables[table][column] = strings.ToLower(typ)
+	}
+	return tables, rows.Err()
+}
+
+// checkSchema reports whether db's tables have the columns initDB would
+// give them, so a database from a different tool or an incompatible version
+// is caught before anything is written to it. A database with no tables at
+// all is fine, as is one with extra tables or columns. Every problem found
+// is reported together.
+func checkSchema(db *sql.DB) error {
+	have, err := tableColumns(db)
+	if err != nil {
+		return err
+	}
+	if len(have) == 0 {
+		return nil
+	}
+
+	// What's expected is whatever initDB creates in an empty database.
+	mem, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return err
+	}
+	defer mem.Close()
+	if err := initDB(mem); err != nil {
+		return err
+	}
+	want, err := tableColumns(mem)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, table := range sortedKeys(want) {
+		if have[table] == nil {
+			problems = append(problems, fmt.Sprintf("missing table %s", table))
+			continue
+		}
+		for _, column := range sortedKeys(want[table]) {
+			typ, ok := have[table][column]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("missing column %s.%s", table, column))
+			case typ != want[table][column]:
+				problems = append(problems, fmt.Sprintf("column %s.%s is %q, want %q", table, column, typ, want[table][column]))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := maps.Keys(m)
+	sort.Strings(keys)
+	return keys
+}
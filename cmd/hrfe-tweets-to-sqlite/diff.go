@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+)
+
+// diffDBs compares the incidents in a and b by tweet ID, writing the tweet
+// IDs only present in one of them and those whose stored fields differ,
+// followed by a summary.
+func diffDBs(w io.Writer, a, b *sql.DB, aName, bName string) error {
+	as, err := incidentsByTweet(a)
+	if err != nil {
+		return fmt.Errorf("%s: %w", aName, err)
+	}
+	bs, err := incidentsByTweet(b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", bName, err)
+	}
+
+	var onlyA, onlyB, changed []int64
+	for id, ain := range as {
+		bin, ok := bs[id]
+		if !ok {
+			onlyA = append(onlyA, id)
+			continue
+		}
+		if len(diffIncident(ain, bin)) > 0 {
+			changed = append(changed, id)
+		}
+	}
+	for id := range bs {
+		if _, ok := as[id]; !ok {
+			onlyB = append(onlyB, id)
+		}
+	}
+
+	for _, ids := range [][]int64{onlyA, onlyB, changed} {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	for _, id := range onlyA {
+		fmt.Fprintf(w, "only in %s: tweet id=%v incident %v\n", aName, id, as[id].ID)
+	}
+	for _, id := range onlyB {
+		fmt.Fprintf(w, "only in %s: tweet id=%v incident %v\n", bName, id, bs[id].ID)
+	}
+	for _, id := range changed {
+		fields := diffIncident(as[id], bs[id])
+		fmt.Fprintf(w, "changed tweet id=%v incident %v: %s\n", id, as[id].ID, strings.Join(fields, ", "))
+	}
+	_, err = fmt.Fprintf(w, "%d only in %s, %d only in %s, %d changed, %d identical\n",
+		len(onlyA), aName, len(onlyB), bName, len(changed), len(as)-len(onlyA)-len(changed))
+	return err
+}
+
+func incidentsByTweet(db *sql.DB) (map[int64]Incident, error) {
+	ins := make(map[int64]Incident)
+	err := eachIncident(db, "", nil, func(in Incident) error {
+		ins[in.TweetID] = in
+		return nil
+	})
+	return ins, err
+}
+
+// diffIncident returns the names of the stored fields that differ between a
+// and b, covering every column in incidentColumns along with the
+// communities and tags.
+func diffIncident(a, b Incident) []string {
+	fields := map[string]bool{
+		"id":             a.ID != b.ID,
+		"location":       a.Location != b.Location,
+		"community":      a.Community != b.Community,
+		"type":           a.Type != b.Type,
+		"apparatuses":    strings.Join(a.Apparatuses, " ") != strings.Join(b.Apparatuses, " "),
+		"stations":       strings.Join(a.Stations, " ") != strings.Join(b.Stations, " "),
+		"created_at":     !a.CreatedAt.Equal(b.CreatedAt),
+		"tweet_text":     a.TweetText != b.TweetText,
+		"type_inferred":  a.TypeInferred != b.TypeInferred,
+		"disposition":    a.Disposition != b.Disposition,
+		"dispatched_at":  !equalTimes(a.DispatchedAt, b.DispatchedAt),
+		"is_test":        a.IsTest != b.IsTest,
+		"tweet_lang":     a.TweetLang != b.TweetLang,
+		"tweet_source":   a.TweetSource != b.TweetSource,
+		"parser_version": a.ParserVersion != b.ParserVersion,
+		"communities":    strings.Join(a.Communities, "/") != strings.Join(b.Communities, "/"),
+		"tags":           strings.Join(a.Tags, " ") != strings.Join(b.Tags, " "),
+	}
+	var diff []string
+	for _, f := range maps.Keys(fields) {
+		if fields[f] {
+			diff = append(diff, f)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// equalTimes reports whether a and b are both unset or the same instant.
+func equalTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffDBs(t *testing.T) {
+	a, b := newTestDB(t), newTestDB(t)
+	insert := func(db *sql.DB, tweetID int64, typ string) {
+		t.Helper()
+		in := Incident{ID: fmt.Sprintf("22-%d", tweetID), Location: "1 MAIN ST", Type: typ, TweetID: tweetID, CreatedAt: testTime}
+		if _, err := insertIncident(db, in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := int64(1); id <= 6; id++ {
+		insert(a, id, "FIRE")
+	}
+	for id := int64(1); id <= 5; id++ {
+		typ := "FIRE"
+		if id >= 3 {
+			typ = "MEDICAL"
+		}
+		insert(b, id, typ)
+	}
+	insert(b, 7, "FIRE")
+
+	want := `only in a: tweet id=6 incident 22-6
+only in b: tweet id=7 incident 22-7
+changed tweet id=3 incident 22-3: type
+changed tweet id=4 incident 22-4: type
+changed tweet id=5 incident 22-5: type
+1 only in a, 1 only in b, 3 changed, 2 identical
+`
+	// Enough times that map order would have shown.
+	for i := 0; i < 10; i++ {
+		var out bytes.Buffer
+		if err := diffDBs(&out, a, b, "a", "b"); err != nil {
+			t.Fatal(err)
+		}
+		if got := out.String(); got != want {
+			t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+		}
+	}
+}
+
+func TestDiffIncidentFields(t *testing.T) {
+	dispatched := testTime.Add(-time.Minute)
+	base := Incident{ID: "22-1", Location: "1 MAIN ST", Type: "FIRE", TweetID: 1, CreatedAt: testTime, DispatchedAt: &dispatched}
+	later := dispatched.Add(time.Minute)
+	for _, tt := range []struct {
+		want   string
+		change func(*Incident)
+	}{
+		{"disposition", func(in *Incident) { in.Disposition = "TRANSPORTED" }},
+		{"is_test", func(in *Incident) { in.IsTest = true }},
+		{"dispatched_at", func(in *Incident) { in.DispatchedAt = &later }},
+		{"dispatched_at", func(in *Incident) { in.DispatchedAt = nil }},
+		{"type_inferred", func(in *Incident) { in.TypeInferred = true }},
+		{"parser_version", func(in *Incident) { in.ParserVersion = parserVersion }},
+		{"tags", func(in *Incident) { in.Tags = []string{"fire"} }},
+		{"communities", func(in *Incident) { in.Communities = []string{"DARTMOUTH"} }},
+		{"tweet_lang", func(in *Incident) { in.TweetLang = "en" }},
+	} {
+		changed := base
+		tt.change(&changed)
+		if got := diffIncident(base, changed); !reflect.DeepEqual(got, []string{tt.want}) {
+			t.Errorf("got %q, want %s", got, tt.want)
+		}
+	}
+
+	// The same instant in another zone is no change.
+	same := base
+	inHalifax := dispatched.In(mustLoadLocation("America/Halifax"))
+	same.DispatchedAt = &inHalifax
+	if got := diffIncident(base, same); got != nil {
+		t.Errorf("got %q, want no change", got)
+	}
+}
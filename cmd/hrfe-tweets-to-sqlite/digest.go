@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"sort"
+	"text/template"
+	"time"
+)
+
+var digestTmpl = template.Must(template.New("digest").Parse(`# HRFE incidents for {{.Date}}
+{{if not .Communities}}
+No incidents.
+{{end}}{{range .Communities}}
+## {{.Name}}
+{{range .Types}}
+- {{.Type}}: {{.Count}}{{end}}
+{{range .Incidents}}
+- {{.CreatedAt.Format "15:04"}} {{.Type}} at {{.Location}} ({{.ID}}){{end}}
+{{end}}`))
+
+type digest struct {
+	Date        string
+	Communities []digestCommunity
+}
+
+type digestCommunity struct {
+	Name      string
+	Types     []typeCount
+	Incidents []Incident
+}
+
+type typeCount struct {
+	Type  string
+	Count int
+}
+
+// writeDigest writes a Markdown summary of the incidents on day, a date in
+// the display time zone, grouped by community.
+func writeDigest(w io.Writer, db *sql.DB, day string) error {
+	start, err := time.ParseInLocation("2006-01-02", day, displayLoc)
+	if err != nil {
+		return err
+	}
+	end := start.AddDate(0, 0, 1)
+
+	byComm := make(map[string][]Incident)
+	if err := eachIncident(db, reportWhere("created_at >= ? and created_at < ?"), []any{start.UTC(), end.UTC()}, func(in Incident) error {
+		in.CreatedAt = local(in.CreatedAt)
+		name := in.Community
+		if name == "" {
+			name = "Unknown community"
+		}
+		byComm[name] = append(byComm[name], in)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	d := digest{Date: day}
+	for name, ins := range byComm {
+		d.Communities = append(d.Communities, digestCommunity{
+			Name:      name,
+			Types:     countTypes(ins),
+			Incidents: ins,
+		})
+	}
+	sort.Slice(d.Communities, func(i, j int) bool { return d.Communities[i].Name < d.Communities[j].Name })
+
+	return digestTmpl.Execute(w, d)
+}
+
+// countTypes counts ins by type, busiest first.
+func countTypes(ins []Incident) []typeCount {
+	counts := make(map[string]int)
+	for _, in := range ins {
+		counts[in.Type]++
+	}
+	tcs := make([]typeCount, 0, len(counts))
+	for t, n := range counts {
+		tcs = append(tcs, typeCount{Type: t, Count: n})
+	}
+	sort.Slice(tcs, func(i, j int) bool {
+		if tcs[i].Count != tcs[j].Count {
+			return tcs[i].Count > tcs[j].Count
+		}
+		return tcs[i].Type < tcs[j].Type
+	})
+	return tcs
+}
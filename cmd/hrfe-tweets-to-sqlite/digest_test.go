@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriteDigest(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	at := func(id int64, text string, d time.Duration) rawTweet {
+		tw := testTweet(id, text)
+		tw.Created = testTime.Add(d)
+		tw.CreatedRaw = tw.Created.Format(time.RubyDate)
+		return tw
+	}
+	if err := im.process([]rawTweet{
+		at(1, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1", 0),
+		at(2, "22-2\n2 ELM ST  HALIFAX\nMEDICAL\nE3", time.Hour),
+		at(3, "22-3\n3 OAK ST  DARTMOUTH\nMEDICAL\nE1", -time.Hour),
+		at(4, "22-4\n4 PINE ST  DARTMOUTH\nMEDICAL\nE1", 2*time.Hour),
+		at(5, "22-5\n5 BIRCH ST\nMVC\nE5", 3*time.Hour),
+		// The next day.
+		at(6, "22-6\n6 MAPLE ST  HALIFAX\nFIRE\nE3", 9*time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := writeDigest(&out, db, "2022-03-04"); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HRFE incidents for 2022-03-04
+
+## DARTMOUTH
+
+- MEDICAL: 2
+- FIRE: 1
+
+- 14:30 MEDICAL at 3 OAK ST (22-3)
+- 15:30 FIRE at 1 MAIN ST (22-1)
+- 17:30 MEDICAL at 4 PINE ST (22-4)
+
+## HALIFAX
+
+- MEDICAL: 1
+
+- 16:30 MEDICAL at 2 ELM ST (22-2)
+
+## Unknown community
+
+- MVC: 1
+
+- 18:30 MVC at 5 BIRCH ST (22-5)
+`
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	out.Reset()
+	if err := writeDigest(&out, db, "2022-03-06"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "# HRFE incidents for 2022-03-06\n\nNo incidents.\n"; got != want {
+		t.Errorf("empty day got:\n%s\nwant:\n%s", got, want)
+	}
+}
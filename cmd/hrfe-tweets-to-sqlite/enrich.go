@@ -0,0 +1,26 @@
+package main
+
+// Enricher adds to or corrects an incident after it's parsed and before it's
+// stored, say by geocoding its location or classifying its type. An error
+// means the incident can't be stored: the tweet is recorded in
+// processing_errors instead, to be retried with -reprocess-errors.
+type Enricher func(*Incident) error
+
+// enrichers run in order on every incident. Add to them with
+// registerEnricher, typically from an init function in a separate file.
+var enrichers []Enricher
+
+// registerEnricher adds e to the end of enrichers.
+func registerEnricher(e Enricher) {
+	enrichers = append(enrichers, e)
+}
+
+// enrich runs every registered enricher on in, stopping at the first error.
+func enrich(in *Incident) error {
+	for _, e := range enrichers {
+		if err := e(in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
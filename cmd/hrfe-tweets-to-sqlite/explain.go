@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// explain writes how parse handles the tweet text s: each input line, then
+// each derived field along with the line it came from, or the parse error.
+func explain(w io.Writer, s string) {
+	for i, l := range strings.Split(html.UnescapeString(s), "\n") {
+		fmt.Fprintf(w, "line %d: %q\n", i, l)
+	}
+	fmt.Fprintln(w)
+
+	in, err := parse(s)
+	if err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+		return
+	}
+	l := parseOpts.lines()
+	if in.RawID != in.ID {
+		fmt.Fprintf(w, "id (line %d, normalized from %q): %q\n", l.id, in.RawID, in.ID)
+	} else {
+		fmt.Fprintf(w, "id (line %d): %q\n", l.id, in.ID)
+	}
+	fmt.Fprintf(w, "location (line %d): %q\n", l.location, in.Location)
+	fmt.Fprintf(w, "community (line %d): %q\n", l.location, in.Community)
+	if len(in.Communities) > 0 {
+		fmt.Fprintf(w, "communities (line %d): %q\n", l.location, in.Communities)
+	}
+	if in.TypeInferred {
+		fmt.Fprintf(w, "type (inferred from line %d): %q\n", l.apparatus, in.Type)
+	} else {
+		fmt.Fprintf(w, "type (line %d): %q\n", l.typ, in.Type)
+	}
+	if in.DispatchClock != "" {
+		fmt.Fprintf(w, "dispatch time: %q\n", in.DispatchClock)
+	}
+	if in.IsTest {
+		fmt.Fprintln(w, "test: marked as a drill or test")
+	}
+	if in.Disposition != "" {
+		fmt.Fprintf(w, "disposition (line %d): %q\n", l.typ, in.Disposition)
+	}
+	if len(in.Apparatuses) == 0 && len(in.Stations) > 0 {
+		fmt.Fprintf(w, "apparatuses (line %d): none, only stations, so the units are unknown\n", l.apparatus)
+	} else {
+		fmt.Fprintf(w, "apparatuses (line %d): %q\n", l.apparatus, in.Apparatuses)
+	}
+	fmt.Fprintf(w, "stations (line %d): %q\n", l.apparatus, in.Stations)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// incidentColumns are the incidents columns scanned by scanIncident, in order.
+const incidentColumns = "id, location, community, type, apparatuses, station, created_at, tweet_id, tweet_text, type_inferred, disposition, dispatched_at, is_test, tweet_lang, tweet_source, parser_version"
+
+// incidentJoinedColumns follow incidentColumns, giving the communities and
+// tags stored for the incident selected as i, each as a JSON array.
+const incidentJoinedColumns = `(select json_group_array(community) from (select community from incident_communities where tweet_id = i.tweet_id order by rowid)),
+	(select json_group_array(tag) from (select tag from incident_tags where tweet_id = i.tweet_id order by tag))`
+
+func scanIncident(rows *sql.Rows) (Incident, error) {
+	var (
+		in                    Incident
+		apparatuses, stations string
+		dispatchedAt          sql.NullTime
+		version               sql.NullInt64
+		communities, tags     string
+	)
+	if err := rows.Scan(&in.ID, &in.Location, &in.Community, &in.Type, &apparatuses, &stations, &in.CreatedAt, &in.TweetID, &in.TweetText, &in.TypeInferred, &in.Disposition, &dispatchedAt, &in.IsTest, &in.TweetLang, &in.TweetSource, &version, &communities, &tags); err != nil {
+		return Incident{}, err
+	}
+	if dispatchedAt.Valid {
+		in.DispatchedAt = &dispatchedAt.Time
+	}
+	in.Apparatuses = uniqueFields(apparatuses)
+	in.Stations = uniqueFields(stations)
+	in.ParserVersion = int(version.Int64)
+	for _, c := range []struct {
+		s  string
+		to *[]string
+	}{{communities, &in.Communities}, {tags, &in.Tags}} {
+		if err := json.Unmarshal([]byte(c.s), c.to); err != nil {
+			return Incident{}, err
+		}
+		if len(*c.to) == 0 {
+			*c.to = nil
+		}
+	}
+	return in, nil
+}
+
+// uniqueFields splits a space-joined list, sorting it and dropping repeats
+// as can happen in incidents_by_id's merged lists.
+func uniqueFields(s string) []string {
+	fs := strings.Fields(s)
+	sort.Strings(fs)
+	out := fs[:0]
+	for i, f := range fs {
+		if i == 0 || f != fs[i-1] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// incidentOrder is the canonical incident ordering. Several incidents can
+// share a created_at second, so the tweet ID breaks ties.
+const incidentOrder = "created_at, tweet_id"
+
+// incidentOrderDesc is incidentOrder reversed, newest first.
+const incidentOrderDesc = "created_at desc, tweet_id desc"
+
+// eachIncident calls fn, in incidentOrder, for every incident matched by the
+// given where clause (which may be empty), without loading them all into
+// memory.
+func eachIncident(db *sql.DB, where string, args []any, fn func(Incident) error) error {
+	return eachIncidentIn(db, "incidents", where, args, fn)
+}
+
+// eachIncidentIn is like eachIncident but reads from table, which may be
+// the incidents_by_id view.
+func eachIncidentIn(db *sql.DB, table, where string, args []any, fn func(Incident) error) error {
+	q := "select " + incidentColumns + ", " + incidentJoinedColumns + " from " + table + " i"
+	if where != "" {
+		q += " where " + where
+	}
+	q += " order by " + incidentOrder
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		in, err := scanIncident(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(in); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// exportOptions are common to all export formats.
+type exportOptions struct {
+	localTime  bool // CreatedAt in the display time zone rather than UTC
+	byIncident bool // one row per incident ID, from incidents_by_id, rather than per tweet
+
+	// redactCommunity is "", redactDrop or redactCoarsen.
+	redactCommunity string
+}
+
+// Ways of redacting communities when exporting.
+const (
+	redactDrop    = "drop"    // remove them
+	redactCoarsen = "coarsen" // replace them with their municipality
+)
+
+// each calls fn for every incident to export.
+func (o exportOptions) each(db *sql.DB, fn func(Incident) error) error {
+	table := "incidents"
+	if o.byIncident {
+		table = "incidents_by_id"
+	}
+	return eachIncidentIn(db, table, "", nil, func(in Incident) error {
+		in.TweetURL = tweetURL(screenName, in.TweetID)
+		switch o.redactCommunity {
+		case redactDrop:
+			in.Community, in.Communities = "", nil
+		case redactCoarsen:
+			in.Community, in.Communities = coarsenCommunity(in.Community), nil
+		}
+		if o.localTime {
+			in.CreatedAt = local(in.CreatedAt)
+		}
+		return fn(in)
+	})
+}
+
+// exportTemplate writes every stored incident to w by executing tmpl
+// against it, one incident per line.
+func exportTemplate(w io.Writer, db *sql.DB, tmpl *template.Template, opts exportOptions) error {
+	return opts.each(db, func(in Incident) error {
+		if err := tmpl.Execute(w, in); err != nil {
+			return fmt.Errorf("incident %v: %w", in.ID, err)
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	})
+}
+
+// Export formats accepted by -export.
+const (
+	exportJSONL     = "jsonl"
+	exportJSONArray = "json-array"
+)
+
+// writeExport calls write with w, or with a gzip writer onto w when gzipped,
+// closing it afterwards so the gzip trailer is written.
+func writeExport(w io.Writer, gzipped bool, write func(io.Writer) error) error {
+	if !gzipped {
+		return write(w)
+	}
+	gz := gzip.NewWriter(w)
+	if err := write(gz); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// exportJSON writes every stored incident to w as JSON, either one object
+// per line or, with array set, as a single array. Incidents are streamed
+// rather than collected first, so large databases don't need large amounts
+// of memory.
+func exportJSON(w io.Writer, db *sql.DB, array bool, opts exportOptions) error {
+	if array {
+		if _, err := io.WriteString(w, "["); err != nil {
+			return err
+		}
+	}
+	first := true
+	if err := opts.each(db, func(in Incident) error {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("incident %v: %w", in.ID, err)
+		}
+		switch {
+		case !array:
+			b = append(b, '\n')
+		case first:
+			b = append([]byte("\n"), b...)
+		default:
+			b = append([]byte(",\n"), b...)
+		}
+		first = false
+		_, err = w.Write(b)
+		return err
+	}); err != nil {
+		return err
+	}
+	if array {
+		end := "\n]\n"
+		if first {
+			end = "]\n"
+		}
+		if _, err := io.WriteString(w, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
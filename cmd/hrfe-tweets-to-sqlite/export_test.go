@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	setTagRules(t, "fire,fire\nre:\\bL[0-9]+\\b,ladder\n")
+	src := newTestDB(t)
+	im := &importer{db: src, quiet: true}
+	tweets := []rawTweet{
+		testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH/COLE HARBOUR\nFIRE @ 11:20\nE1 L4 STN2"),
+		testTweet(11, "22-2\n2 ELM ST  HALIFAX\nMEDICAL - Transported\nE3"),
+		testTweet(12, "22-3\n3 OAK ST  BEDFORD\nDRILL\nSTN5"),
+	}
+	tweets[0].Lang = "en"
+	if err := im.process(tweets); err != nil {
+		t.Fatal(err)
+	}
+	// One stored by an older parser, and one from before versions were
+	// stored.
+	if _, err := src.Exec("update incidents set parser_version = 3 where tweet_id = 11"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Exec("update incidents set parser_version = null where tweet_id = 12"); err != nil {
+		t.Fatal(err)
+	}
+
+	var exported bytes.Buffer
+	if err := exportJSON(&exported, src, false, exportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newTestDB(t)
+	input := exported.String() + "not json\n" + `{"id": "22-9"}` + "\n"
+	inserted, existing, malformed, err := importJSONL(dst, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 3 || existing != 0 || malformed != 2 {
+		t.Errorf("inserted %d, existing %d, malformed %d, want 3, 0, 2", inserted, existing, malformed)
+	}
+
+	var reexported bytes.Buffer
+	if err := exportJSON(&reexported, dst, false, exportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if exported.String() != reexported.String() {
+		t.Errorf("round trip changed the export:\n%s\nthen:\n%s", exported.String(), reexported.String())
+	}
+	for _, q := range []string{
+		"select group_concat(tweet_id || ':' || coalesce(parser_version, 'null'), ' ') from (select * from incidents order by tweet_id)",
+		"select group_concat(tweet_id || ':' || tag, ' ') from (select * from incident_tags order by tweet_id, tag)",
+		"select group_concat(tweet_id || ':' || community, ' ') from (select * from incident_communities order by rowid)",
+	} {
+		var want, got string
+		if err := src.QueryRow(q).Scan(&want); err != nil {
+			t.Fatal(err)
+		}
+		if err := dst.QueryRow(q).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", q, got, want)
+		}
+	}
+
+	// Importing again finds everything already there.
+	inserted, existing, _, err = importJSONL(dst, strings.NewReader(exported.String()))
+	if err != nil || inserted != 0 || existing != 3 {
+		t.Errorf("second import: inserted %d, existing %d, %v, want 0, 3", inserted, existing, err)
+	}
+}
+
+func TestExportSameSecondOrder(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	var tweets []rawTweet
+	// Stored out of order, all posted in the same second but for one a
+	// second earlier with the highest ID.
+	for _, id := range []int64{30, 10, 20, 40} {
+		tw := testTweet(id, fmt.Sprintf("22-%d\n%d MAIN ST  DARTMOUTH\nFIRE\nE1", id, id))
+		if id == 40 {
+			tw.Created = tw.Created.Add(-time.Second)
+		}
+		tweets = append(tweets, tw)
+	}
+	if err := im.process(tweets); err != nil {
+		t.Fatal(err)
+	}
+
+	// Enough times that an unstable order would have shown.
+	for i := 0; i < 5; i++ {
+		var out bytes.Buffer
+		if err := exportJSON(&out, db, false, exportOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		dec := json.NewDecoder(&out)
+		for dec.More() {
+			var in Incident
+			if err := dec.Decode(&in); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, in.TweetID)
+		}
+		if want := []int64{40, 10, 20, 30}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("exported tweets %v, want %v", got, want)
+		}
+	}
+}
+
+func TestExportTweetURL(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(1500000000000000001, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1"),
+		testTweet(1500000000000000002, "22-2\n2 ELM ST  HALIFAX\nMEDICAL\nE3"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := exportJSON(&out, db, false, exportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var row map[string]any
+		if err := dec.Decode(&row); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprint(row["tweet_url"]))
+	}
+	want := []string{
+		"https://twitter.com/HRFE_Incidents/status/1500000000000000001",
+		"https://twitter.com/HRFE_Incidents/status/1500000000000000002",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exported URLs %q, want %q", got, want)
+	}
+
+	// Templates and merged incidents get them too.
+	for _, byIncident := range []bool{false, true} {
+		out.Reset()
+		tmpl := template.Must(template.New("").Parse("{{.TweetURL}}"))
+		if err := exportTemplate(&out, db, tmpl, exportOptions{byIncident: byIncident}); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Fields(out.String()); !reflect.DeepEqual(got, want) {
+			t.Errorf("by incident %v: got %q, want %q", byIncident, got, want)
+		}
+	}
+}
+
+func TestExportJSONArray(t *testing.T) {
+	for _, n := range []int{0, 1, 5} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			db := newTestDB(t)
+			var tweets []rawTweet
+			for i := 1; i <= n; i++ {
+				tweets = append(tweets, testTweet(int64(i), fmt.Sprintf("22-%d\n%d MAIN ST  DARTMOUTH\nFIRE\nE1", i, i)))
+			}
+			im := &importer{db: db, quiet: true}
+			if err := im.process(tweets); err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			if err := exportJSON(&out, db, true, exportOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			var got []map[string]interface{}
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatalf("invalid JSON %q: %v", out.String(), err)
+			}
+			if got == nil || len(got) != n {
+				t.Errorf("got %d objects in %q, want an array of %d", len(got), out.String(), n)
+			}
+			if n == 0 && strings.TrimSpace(out.String()) != "[]" {
+				t.Errorf("got %q, want []", out.String())
+			}
+		})
+	}
+}
+
+func TestWriteExportGzip(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1"),
+		testTweet(11, "22-2\n2 ELM ST  HALIFAX\nMEDICAL\nE3"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	csv := template.Must(template.New("").Parse(`{{.ID}},{{.Location}},{{.Type}}`))
+
+	for name, write := range map[string]func(io.Writer) error{
+		"jsonl":      func(w io.Writer) error { return exportJSON(w, db, false, exportOptions{}) },
+		"json-array": func(w io.Writer) error { return exportJSON(w, db, true, exportOptions{}) },
+		"template":   func(w io.Writer) error { return exportTemplate(w, db, csv, exportOptions{}) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var plain, gzipped bytes.Buffer
+			if err := writeExport(&plain, false, write); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeExport(&gzipped, true, write); err != nil {
+				t.Fatal(err)
+			}
+			zr, err := gzip.NewReader(&gzipped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Reading to the end checks the trailer too.
+			got, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plain.Len() == 0 || string(got) != plain.String() {
+				t.Errorf("decompressed to %q, want %q", got, plain.String())
+			}
+		})
+	}
+}
+
+func TestExportTemplate(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1 L4"),
+		testTweet(11, "22-2\n2 ELM ST, HALIFAX\nMEDICAL\nE3"),
+		testTweet(12, "22-3\n3 OAK ST\nMVC\nSTN5"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The README's example, plus the apparatus.
+	tmpl := template.Must(template.New("incident").Parse(`{{.Type}} at {{.Location}} ({{.Community}}) {{.Apparatuses}}`))
+
+	var out bytes.Buffer
+	if err := exportTemplate(&out, db, tmpl, exportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := "FIRE at 1 MAIN ST (DARTMOUTH) [E1 L4]\n" +
+		"MEDICAL at 2 ELM ST (HALIFAX) [E3]\n" +
+		"MVC at 3 OAK ST () []\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestImportLocalTime(t *testing.T) {
+	defer func(old *time.Location) { displayLoc = old }(displayLoc)
+	displayLoc = mustLoadLocation("America/Halifax")
+
+	src := newTestDB(t)
+	im := &importer{db: src, quiet: true}
+	// Posted at 15:30 UTC, 11:30 in Halifax.
+	if err := im.process([]rawTweet{testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE @ 11:20\nE1")}); err != nil {
+		t.Fatal(err)
+	}
+	var exported bytes.Buffer
+	if err := exportJSON(&exported, src, false, exportOptions{localTime: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(exported.String(), "-04:00") {
+		t.Fatalf("export isn't in local time: %s", exported.String())
+	}
+
+	// A tweet from half an hour earlier, already stored in UTC.
+	dst := newTestDB(t)
+	im = &importer{db: dst, quiet: true}
+	earlier := testTweet(11, "22-2\n2 ELM ST  HALIFAX\nMEDICAL\nE3")
+	earlier.Created = testTime.Add(-30 * time.Minute)
+	if err := im.process([]rawTweet{earlier}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := importJSONL(dst, &exported); err != nil {
+		t.Fatal(err)
+	}
+
+	var order []int64
+	if err := eachIncident(dst, "created_at >= ?", []any{testTime.Add(-time.Hour)}, func(in Incident) error {
+		order = append(order, in.TweetID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 2 || order[0] != 11 || order[1] != 10 {
+		t.Errorf("got tweets in order %v, want 11 then 10", order)
+	}
+	var createdAt, dispatchedAt string
+	if err := dst.QueryRow("select cast(created_at as text), cast(dispatched_at as text) from incidents where tweet_id = 10").Scan(&createdAt, &dispatchedAt); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(createdAt, "2022-03-04 15:30:00") || !strings.HasPrefix(dispatchedAt, "2022-03-04 15:20:00") {
+		t.Errorf("stored created_at %q and dispatched_at %q, want them in UTC", createdAt, dispatchedAt)
+	}
+}
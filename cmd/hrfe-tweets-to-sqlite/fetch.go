@@ -0,0 +1,492 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// fetchOptions controls how far back a run reaches.
+type fetchOptions struct {
+	backfillPages int  // 0 means no limit
+	noBackfill    bool // only fetch tweets newer than any stored
+}
+
+// fetch processes every tweet newer than the newest stored one, then walks
+// back through older tweets until the timeline runs out or a limit is hit.
+// A backfill left incomplete by an earlier run's page limit is resumed
+// first, so a long history gets imported over several scheduled runs.
+func fetch(twc *twitter.Client, im *importer, budget *apiBudget, opts fetchOptions) {
+	if opts.noBackfill {
+		fetchNewer(twc, im, budget)
+		return
+	}
+
+	minID, pending, err := pendingBackfill(im.db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pending {
+		im.logf("resuming incomplete backfill from id=%d", minID)
+		backfill(twc, im, budget, opts)
+		fetchNewer(twc, im, budget)
+		return
+	}
+
+	fetchNewer(twc, im, budget)
+	backfill(twc, im, budget, opts)
+}
+
+// fetchNewer processes every tweet newer than the newest stored one.
+func fetchNewer(twc *twitter.Client, im *importer, budget *apiBudget) {
+	for {
+		if budget.exhausted() {
+			im.logf("API call budget of %d reached", budget.max)
+			return
+		}
+
+		max, err := maxTweetID(im.db)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tweets, err := tweetsSince(twc, budget, max)
+		if errors.Is(err, errTweetGone) {
+			// Asking for the same page again would fail the same way, so
+			// treat it as the end of the newer tweets. The next run picks
+			// up from the same place.
+			im.logf("stopping at an unavailable page of newer tweets: %v", err)
+			break
+		}
+		if err != nil {
+			fetchFailed(err)
+		}
+		tweets = dropOutOfRange(im, tweets, max, 0)
+		if len(tweets) == 0 {
+			break
+		}
+
+		// Tweets come newest first. Store them oldest first so that if a
+		// run stops part way through a page, the next one asks for the
+		// tweets after the newest one actually stored.
+		reverse(tweets)
+		if err := im.process(tweets); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// backfill walks back through tweets older than the oldest stored one until
+// the timeline runs out, recording whether it got there.
+func backfill(twc *twitter.Client, im *importer, budget *apiBudget, opts fetchOptions) {
+	// With nothing stored there's nothing to walk back from, and asking
+	// for tweets before ID 0 makes no sense.
+	seen, err := seenTweetCount(im.db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if seen == 0 {
+		im.logf("database is empty and no tweets were fetched from @%s, nothing to do", screenName)
+		return
+	}
+
+	if im.progress != nil {
+		defer im.progress.done()
+	}
+
+	var pages, pageSize, statusesCount int
+	for {
+		if budget.exhausted() {
+			im.logf("API call budget of %d reached", budget.max)
+			if pages > 0 {
+				markBackfillStopped(im.db)
+			}
+			return
+		}
+		if opts.backfillPages > 0 && pages >= opts.backfillPages {
+			seen, err := seenTweetCount(im.db)
+			if err != nil {
+				log.Fatal(err)
+			}
+			remaining := 0
+			if statusesCount > seen && pageSize > 0 {
+				remaining = (statusesCount - seen + pageSize - 1) / pageSize
+			}
+			im.logf("backfill page limit of %d reached, roughly %d pages remain", opts.backfillPages, remaining)
+			markBackfillStopped(im.db)
+			break
+		}
+
+		min, err := minTweetID(im.db)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tweets, err := tweetsUntil(twc, budget, min)
+		if errors.Is(err, errTweetGone) {
+			// As for newer tweets, but the backfill isn't finished, so
+			// the next run resumes it.
+			im.logf("stopping backfill at an unavailable page: %v", err)
+			markBackfillStopped(im.db)
+			break
+		}
+		if err != nil {
+			fetchFailed(err)
+		}
+		tweets = dropOutOfRange(im, tweets, 0, min-1)
+		pages++
+		if len(tweets) == 0 {
+			if err := clearBackfill(im.db); err != nil {
+				log.Fatal(err)
+			}
+			break
+		}
+		pageSize = len(tweets)
+		if n := tweets[0].AuthorTweetCount; n > 0 {
+			statusesCount = n
+		}
+
+		if err := im.process(tweets); err != nil {
+			log.Fatal(err)
+		}
+
+		// Each page should take us further back. If it didn't, asking
+		// again would just return the same page forever.
+		newMin, err := minTweetID(im.db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if min != 0 && newMin >= min {
+			log.Printf("warning: oldest tweet id=%v did not move back after a page, stopping backfill", newMin)
+			break
+		}
+
+		if im.progress != nil {
+			seen, err := seenTweetCount(im.db)
+			if err != nil {
+				log.Fatal(err)
+			}
+			im.progress.update(seen, statusesCount, tweetIDTime(newMin))
+		}
+	}
+}
+
+// markBackfillStopped records that a backfill stopped short of the start of
+// the timeline at the oldest stored tweet.
+func markBackfillStopped(db *sql.DB) {
+	min, err := minTweetID(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := markBackfillIncomplete(db, min); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// dropOutOfRange removes tweets outside the range a page was requested
+// for: after sinceID and up to and including maxID, either 0 for none. A
+// pinned tweet can turn up at the top of a timeline regardless of the
+// range, and processing it would throw off where the next page starts. One
+// older than the tweet after it is dropped too, since it's inside the range
+// when walking back but would still pull the oldest stored tweet past
+// everything in between.
+func dropOutOfRange(im *importer, tweets []rawTweet, sinceID, maxID int64) []rawTweet {
+	kept := tweets[:0]
+	for i, tw := range tweets {
+		if tw.ID <= sinceID || (maxID != 0 && tw.ID > maxID) {
+			im.logf("skipping tweet id=%v outside the requested range, probably pinned", tw.ID)
+			continue
+		}
+		if i == 0 && len(tweets) > 1 && tw.ID < tweets[1].ID {
+			im.logf("skipping tweet id=%v older than the rest of its page, probably pinned", tw.ID)
+			continue
+		}
+		kept = append(kept, tw)
+	}
+	return kept
+}
+
+// fetchRange processes the tweets with IDs after sinceID and up to and
+// including maxID, ignoring what is already stored. Either bound may be 0
+// for none. It's meant for re-fetching a window that may be incomplete.
+func fetchRange(twc *twitter.Client, im *importer, budget *apiBudget, sinceID, maxID int64) {
+	for {
+		if budget.exhausted() {
+			im.logf("API call budget of %d reached", budget.max)
+			return
+		}
+
+		tweets, err := tweetsBetween(twc, budget, sinceID, maxID)
+		if errors.Is(err, errTweetGone) {
+			im.logf("stopping at an unavailable page: %v", err)
+			return
+		}
+		if err != nil {
+			fetchFailed(err)
+		}
+		tweets = dropOutOfRange(im, tweets, sinceID, maxID)
+		if len(tweets) == 0 {
+			return
+		}
+
+		if err := im.process(tweets); err != nil {
+			log.Fatal(err)
+		}
+
+		// Pages come newest first, so continue below the oldest one.
+		maxID = tweets[len(tweets)-1].ID - 1
+		if maxID <= sinceID {
+			return
+		}
+	}
+}
+
+// checkRange validates -since-id and -max-id, where 0 means unset. Ranges
+// spanning more than maxRange need confirming. With only -max-id there's no
+// start to measure from, and the timeline API only reaches back a few
+// thousand tweets anyway, so that needs no confirming.
+func checkRange(sinceID, maxID int64, now time.Time, confirmed bool) error {
+	if sinceID < 0 || maxID < 0 {
+		return errors.New("tweet IDs can't be negative")
+	}
+	if maxID != 0 && sinceID >= maxID {
+		return fmt.Errorf("since id %v must be less than max id %v", sinceID, maxID)
+	}
+	if sinceID == 0 {
+		return nil
+	}
+	from, to := tweetIDTime(sinceID), now
+	if maxID != 0 {
+		to = tweetIDTime(maxID)
+	}
+	if span := to.Sub(from); span > maxRange && !confirmed {
+		return fmt.Errorf("range covers %v, more than %v; pass -yes to fetch it anyway", span.Round(time.Hour), maxRange)
+	}
+	return nil
+}
+
+// maxRange is the longest span of tweets -since-id and -max-id fetch without
+// confirmation.
+const maxRange = 30 * 24 * time.Hour
+
+func reverse(tweets []rawTweet) {
+	for i, j := 0, len(tweets)-1; i < j; i, j = i+1, j-1 {
+		tweets[i], tweets[j] = tweets[j], tweets[i]
+	}
+}
+
+// apiBudget counts timeline API calls made during a run and optionally caps
+// them so a deep backfill can't use up the monthly quota in one go.
+type apiBudget struct {
+	calls int
+	max   int // 0 means no limit
+}
+
+func (b *apiBudget) exhausted() bool {
+	return b.max > 0 && b.calls >= b.max
+}
+
+// twitterEpoch is the Unix time in milliseconds that tweet IDs count from.
+const twitterEpoch = 1288834974657
+
+// tweetIDTime returns when the tweet with the given ID was posted, to the
+// millisecond, using the timestamp embedded in the ID.
+func tweetIDTime(id int64) time.Time {
+	return time.UnixMilli(id>>22 + twitterEpoch).UTC()
+}
+
+// accountUserID, when set from -user-id, is used to fetch the timeline
+// instead of screenName. Unlike the screen name it survives the account
+// being renamed.
+var accountUserID int64
+
+// resolveUserID looks up the numeric user ID of the account with the given
+// screen name.
+func resolveUserID(twc *twitter.Client, name string) (int64, error) {
+	u, resp, err := twc.Users.Show(&twitter.UserShowParams{ScreenName: name})
+	if err := timelineError(resp, err); err != nil {
+		return 0, err
+	}
+	return u.ID, nil
+}
+
+// maxPageSize is the most tweets a single timeline request can return.
+const maxPageSize = 200
+
+// tweetsSample returns up to n of the newest tweets with a single request.
+func tweetsSample(twc *twitter.Client, budget *apiBudget, n int) ([]rawTweet, error) {
+	if n > maxPageSize {
+		n = maxPageSize
+	}
+	params := &twitter.UserTimelineParams{
+		ScreenName: screenName,
+		TweetMode:  "extended",
+		Count:      n,
+	}
+	return userTimeline(twc, budget, params)
+}
+
+func tweetsSince(twc *twitter.Client, budget *apiBudget, id int64) ([]rawTweet, error) {
+	params := &twitter.UserTimelineParams{
+		ScreenName: screenName,
+		TweetMode:  "extended",
+		SinceID:    id,
+	}
+	return userTimeline(twc, budget, params)
+}
+
+func tweetsUntil(twc *twitter.Client, budget *apiBudget, id int64) ([]rawTweet, error) {
+	params := &twitter.UserTimelineParams{
+		ScreenName: screenName,
+		TweetMode:  "extended",
+		MaxID:      id - 1,
+	}
+	return userTimeline(twc, budget, params)
+}
+
+// tweetsBetween returns tweets with IDs after sinceID and up to and
+// including maxID. Either bound may be 0 for none.
+func tweetsBetween(twc *twitter.Client, budget *apiBudget, sinceID, maxID int64) ([]rawTweet, error) {
+	params := &twitter.UserTimelineParams{
+		ScreenName: screenName,
+		TweetMode:  "extended",
+		SinceID:    sinceID,
+		MaxID:      maxID,
+	}
+	return userTimeline(twc, budget, params)
+}
+
+// userTimeline requests a page of the timeline. When rate limited it waits
+// as long as the response says to, or backs off when it doesn't say, and
+// tries again up to rateLimitRetries times.
+func userTimeline(twc *twitter.Client, budget *apiBudget, params *twitter.UserTimelineParams) ([]rawTweet, error) {
+	if accountUserID != 0 {
+		params.ScreenName, params.UserID = "", accountUserID
+	}
+	backoff := rateLimitBackoff
+	for i := 0; ; i++ {
+		budget.calls++
+		tweets, resp, err := twc.Timelines.UserTimeline(params)
+		err = timelineError(resp, err)
+		var rl rateLimitError
+		if !errors.As(err, &rl) || i == rateLimitRetries || budget.exhausted() {
+			if err != nil {
+				return nil, err
+			}
+			return fromTwitter(tweets), nil
+		}
+		wait := rl.retryAfter
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		log.Printf("rate limited, waiting %v", wait)
+		time.Sleep(wait)
+	}
+}
+
+// Waiting out rate limits.
+const (
+	rateLimitRetries = 3
+	rateLimitBackoff = time.Minute // doubled after each retry, when not told how long to wait
+)
+
+// rateLimitError is returned by timelineError for a 429 response, with how
+// long the response said to wait, or 0 if it didn't.
+type rateLimitError struct {
+	retryAfter time.Duration
+	err        error
+}
+
+func (e rateLimitError) Error() string { return e.err.Error() }
+func (e rateLimitError) Unwrap() error { return e.err }
+
+// retryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date, into how long to wait from now. It returns 0
+// when the header is missing, bad or in the past.
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+var (
+	// errProtected means the account's tweets can't be seen, usually because
+	// it has been protected.
+	errProtected = errors.New("account is protected or not visible")
+	// errAccountNotFound means there's no account by that name, as after
+	// it's been renamed.
+	errAccountNotFound = errors.New("account not found")
+	// errTweetGone means a tweet was deleted while being fetched.
+	errTweetGone = errors.New("tweet no longer available")
+)
+
+// timelineError maps the Twitter API errors we handle specially to
+// rateLimitError, errProtected, errAccountNotFound and errTweetGone,
+// wrapping the original error.
+func timelineError(resp *http.Response, err error) error {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		if err == nil {
+			err = errors.New(resp.Status)
+		}
+		return rateLimitError{retryAfter(resp.Header, time.Now()), err}
+	}
+	var apiErr twitter.APIError
+	if errors.As(err, &apiErr) {
+		for _, d := range apiErr.Errors {
+			switch d.Code {
+			case 179: // Sorry, you are not authorized to see this status.
+				return fmt.Errorf("%w: %v", errProtected, err)
+			case 144, 421, 422: // No status found with that ID, this Tweet is no longer available.
+				return fmt.Errorf("%w: %v", errTweetGone, err)
+			case 34, 50: // Sorry, that page does not exist; User not found.
+				return fmt.Errorf("%w: %v", errAccountNotFound, err)
+			}
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	// Protected timelines come back as a bare 401 without an API error body.
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%w: %v", errProtected, resp.Status)
+	}
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", errAccountNotFound, resp.Status)
+	}
+	// Any other failure without an error body would otherwise look like
+	// an empty page.
+	if resp != nil && resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// fetchFailed exits after a timeline error that can't be retried.
+func fetchFailed(err error) {
+	if errors.Is(err, errProtected) {
+		log.Fatalf("can't read @%s, check that the account is public and the credentials are valid: %v", screenName, err)
+	}
+	if errors.Is(err, errAccountNotFound) && accountUserID == 0 {
+		log.Fatalf("can't find @%s, if it was renamed fetch by its numeric ID with -user-id: %v", screenName, err)
+	}
+	log.Fatal(err)
+}
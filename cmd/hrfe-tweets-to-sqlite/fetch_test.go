@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// stubTransport answers every request without touching the network.
+type stubTransport func(*http.Request) *http.Response
+
+func (f stubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r), nil
+}
+
+// stubClient returns a Twitter client whose requests are answered by f.
+func stubClient(f func(*http.Request) *http.Response) *twitter.Client {
+	return twitter.NewClient(&http.Client{Transport: stubTransport(f)})
+}
+
+func stubResponse(status int, body string) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// timelineBody returns a timeline API response body holding a tweet for
+// each ID, newest first as given, each its own incident posted at testTime.
+func timelineBody(ids ...int64) string {
+	tweets := make([]twitter.Tweet, len(ids))
+	for i, id := range ids {
+		tweets[i] = twitter.Tweet{
+			ID:        id,
+			IDStr:     fmt.Sprint(id),
+			FullText:  fmt.Sprintf("22-%d\n%d MAIN ST  DARTMOUTH\nFIRE\nE1", id, id),
+			CreatedAt: testTime.Format(time.RubyDate),
+		}
+	}
+	b, err := json.Marshal(tweets)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+func TestTimelineErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"deleted tweet", http.StatusNotFound, `{"errors":[{"code":144,"message":"No status found with that ID."}]}`, errTweetGone},
+		{"unavailable tweet", http.StatusForbidden, `{"errors":[{"code":421,"message":"This Tweet is no longer available."}]}`, errTweetGone},
+		{"not authorized", http.StatusForbidden, `{"errors":[{"code":179,"message":"Sorry, you are not authorized to see this status."}]}`, errProtected},
+		{"bare 401", http.StatusUnauthorized, ``, errProtected},
+		{"renamed account", http.StatusNotFound, `{"errors":[{"code":34,"message":"Sorry, that page does not exist."}]}`, errAccountNotFound},
+		{"bare 404", http.StatusNotFound, ``, errAccountNotFound},
+		{"ok", http.StatusOK, `[]`, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			twc := stubClient(func(*http.Request) *http.Response { return stubResponse(tt.status, tt.body) })
+			_, err := tweetsSince(twc, &apiBudget{}, 1)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("other API error", func(t *testing.T) {
+		twc := stubClient(func(*http.Request) *http.Response {
+			return stubResponse(http.StatusBadRequest, `{"errors":[{"code":44,"message":"bad parameter"}]}`)
+		})
+		_, err := tweetsSince(twc, &apiBudget{}, 1)
+		if err == nil || errors.Is(err, errTweetGone) || errors.Is(err, errProtected) || errors.Is(err, errAccountNotFound) {
+			t.Fatalf("got %v, want the API error unmapped", err)
+		}
+	})
+
+	t.Run("bare 500", func(t *testing.T) {
+		twc := stubClient(func(*http.Request) *http.Response { return stubResponse(http.StatusInternalServerError, ``) })
+		if _, err := tweetsSince(twc, &apiBudget{}, 1); err == nil {
+			t.Fatal("got no error, want one")
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		twc := stubClient(func(*http.Request) *http.Response {
+			resp := stubResponse(http.StatusTooManyRequests, `{"errors":[{"code":88,"message":"Rate limit exceeded"}]}`)
+			resp.Header.Set("Retry-After", "5")
+			return resp
+		})
+		// With the budget used up it gives up rather than waiting.
+		_, err := tweetsSince(twc, &apiBudget{max: 1}, 1)
+		var rl rateLimitError
+		if !errors.As(err, &rl) || rl.retryAfter != 5*time.Second {
+			t.Fatalf("got %v, want a rate limit error waiting 5s", err)
+		}
+	})
+}
+
+func TestFetchUnavailablePage(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{testTweet(100, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int
+	twc := stubClient(func(*http.Request) *http.Response {
+		calls++
+		return stubResponse(http.StatusNotFound, `{"errors":[{"code":144,"message":"No status found with that ID."}]}`)
+	})
+	fetch(twc, im, &apiBudget{}, fetchOptions{})
+
+	// One request each way, rather than asking for the same page again.
+	if calls != 2 {
+		t.Errorf("made %d requests, want 2", calls)
+	}
+	if id, ok, err := pendingBackfill(db); err != nil || !ok || id != 100 {
+		t.Errorf("pendingBackfill = %v, %v, %v, want 100, true", id, ok, err)
+	}
+
+	// Once the page comes back, the backfill finishes.
+	twc = stubClient(func(*http.Request) *http.Response { return stubResponse(http.StatusOK, `[]`) })
+	fetch(twc, im, &apiBudget{}, fetchOptions{})
+	if _, ok, err := pendingBackfill(db); err != nil || ok {
+		t.Errorf("pendingBackfill = %v, %v, want none", ok, err)
+	}
+}
+
+func TestFetchEmpty(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db}
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	var queries []string
+	twc := stubClient(func(r *http.Request) *http.Response {
+		queries = append(queries, r.URL.RawQuery)
+		return stubResponse(http.StatusOK, `[]`)
+	})
+	fetch(twc, im, &apiBudget{}, fetchOptions{})
+
+	// Only the request for newer tweets, with nothing stored to walk back
+	// from.
+	if len(queries) != 1 || strings.Contains(queries[0], "max_id") {
+		t.Errorf("made requests %q, want one without max_id", queries)
+	}
+	if !strings.Contains(logged.String(), "database is empty and no tweets were fetched") {
+		t.Errorf("logged %q, want the empty database message", logged.String())
+	}
+	if _, ok, err := pendingBackfill(db); err != nil || ok {
+		t.Errorf("pendingBackfill = %v, %v, want none", ok, err)
+	}
+	if n := countRows(t, db, "incidents"); n != 0 {
+		t.Errorf("got %d incidents, want none", n)
+	}
+}
+
+func TestBackfillStopsWithoutProgress(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{testTweet(100, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same page whatever max_id asks for: once 90 is stored it brings
+	// nothing older than the oldest stored tweet, so the oldest can't move
+	// back and asking again would loop forever.
+	var calls int
+	twc := stubClient(func(*http.Request) *http.Response {
+		calls++
+		if calls > 5 {
+			t.Fatal("backfill kept asking for the same page")
+		}
+		return stubResponse(http.StatusOK, timelineBody(100, 90))
+	})
+	backfill(twc, im, &apiBudget{}, fetchOptions{})
+
+	if calls != 2 {
+		t.Errorf("made %d requests, want 2", calls)
+	}
+	if id, err := minTweetID(db); err != nil || id != 90 {
+		t.Errorf("minTweetID = %v, %v, want 90", id, err)
+	}
+	if n := countRows(t, db, "incidents"); n != 2 {
+		t.Errorf("got %d incidents, want 2", n)
+	}
+}
+
+func TestBackfillPageLimit(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{testTweet(100, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A timeline answered two tweets a page, newest first, which grows a
+	// tweet between the first and second runs.
+	timeline := []int64{99, 98, 97, 96, 95, 94, 93, 92, 91}
+	twc := stubClient(func(r *http.Request) *http.Response {
+		q := r.URL.Query()
+		sinceID, _ := strconv.ParseInt(q.Get("since_id"), 10, 64)
+		maxID, err := strconv.ParseInt(q.Get("max_id"), 10, 64)
+		if err != nil {
+			maxID = 1<<63 - 1
+		}
+		var page []int64
+		for _, id := range timeline {
+			if id > sinceID && id <= maxID && len(page) < 2 {
+				page = append(page, id)
+			}
+		}
+		return stubResponse(http.StatusOK, timelineBody(page...))
+	})
+
+	for i, tt := range []struct {
+		newer       int64 // posted before the run, 0 for none
+		wantPending int64 // 0 for none
+		wantMin     int64
+	}{
+		// Stops at the limit, two pages back.
+		{0, 96, 96},
+		// Resumes from there, and still gets the new tweet.
+		{101, 92, 92},
+		// Reaches the start of the timeline.
+		{0, 0, 91},
+		// Nothing left to do.
+		{0, 0, 91},
+	} {
+		if tt.newer != 0 {
+			timeline = append([]int64{tt.newer}, timeline...)
+		}
+		fetch(twc, im, &apiBudget{}, fetchOptions{backfillPages: 2})
+
+		id, ok, err := pendingBackfill(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (tt.wantPending != 0) || id != tt.wantPending {
+			t.Errorf("run %d: pendingBackfill = %v, %v, want %v", i+1, id, ok, tt.wantPending)
+		}
+		if min, err := minTweetID(db); err != nil || min != tt.wantMin {
+			t.Errorf("run %d: minTweetID = %v, %v, want %v", i+1, min, err, tt.wantMin)
+		}
+	}
+	if max, err := maxTweetID(db); err != nil || max != 101 {
+		t.Errorf("maxTweetID = %v, %v, want 101", max, err)
+	}
+	if n := countRows(t, db, "incidents"); n != 11 {
+		t.Errorf("got %d incidents, want 11", n)
+	}
+}
+
+func TestBackfillPinnedTweet(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{testTweet(100, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two tweets a page, under an old pinned one that tops every page.
+	const pinned = 10
+	timeline := []int64{90, 80, 70, 60}
+	var calls int
+	twc := stubClient(func(r *http.Request) *http.Response {
+		calls++
+		if calls > 10 {
+			t.Fatal("backfill didn't finish")
+		}
+		maxID, err := strconv.ParseInt(r.URL.Query().Get("max_id"), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := []int64{pinned}
+		for _, id := range timeline {
+			if id <= maxID && len(page) < 3 {
+				page = append(page, id)
+			}
+		}
+		return stubResponse(http.StatusOK, timelineBody(page...))
+	})
+	backfill(twc, im, &apiBudget{}, fetchOptions{})
+
+	// Had the pinned tweet been stored from the first page, the backfill
+	// would have walked back from it and never seen 70 or 60.
+	var got []int64
+	rows, err := db.Query("select tweet_id from incidents order by tweet_id desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+	if want := []int64{100, 90, 80, 70, 60, pinned}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+	if _, ok, err := pendingBackfill(db); err != nil || ok {
+		t.Errorf("pendingBackfill = %v, %v, want none", ok, err)
+	}
+}
+
+func TestTimelineAccountParams(t *testing.T) {
+	defer func(old int64) { accountUserID = old }(accountUserID)
+	var query url.Values
+	twc := stubClient(func(r *http.Request) *http.Response {
+		query = r.URL.Query()
+		return stubResponse(http.StatusOK, `[]`)
+	})
+
+	for _, tt := range []struct {
+		userID               int64
+		wantName, wantUserID string
+	}{
+		{0, screenName, ""},
+		// The numeric ID survives a rename, so it's used alone.
+		{12345, "", "12345"},
+	} {
+		accountUserID = tt.userID
+		for name, f := range map[string]func() ([]rawTweet, error){
+			"since":   func() ([]rawTweet, error) { return tweetsSince(twc, &apiBudget{}, 1) },
+			"until":   func() ([]rawTweet, error) { return tweetsUntil(twc, &apiBudget{}, 100) },
+			"between": func() ([]rawTweet, error) { return tweetsBetween(twc, &apiBudget{}, 1, 100) },
+			"sample":  func() ([]rawTweet, error) { return tweetsSample(twc, &apiBudget{}, 5) },
+		} {
+			if _, err := f(); err != nil {
+				t.Fatal(err)
+			}
+			if got := query.Get("screen_name"); got != tt.wantName {
+				t.Errorf("user id %v, %s: screen_name %q, want %q", tt.userID, name, got, tt.wantName)
+			}
+			if got := query.Get("user_id"); got != tt.wantUserID {
+				t.Errorf("user id %v, %s: user_id %q, want %q", tt.userID, name, got, tt.wantUserID)
+			}
+		}
+	}
+}
+
+func TestCheckRange(t *testing.T) {
+	now := time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC)
+	// idAt returns a tweet ID posted at t.
+	idAt := func(t time.Time) int64 { return (t.UnixMilli() - twitterEpoch) << 22 }
+	weekAgo, yearAgo := idAt(now.AddDate(0, 0, -7)), idAt(now.AddDate(-1, 0, 0))
+
+	for _, tt := range []struct {
+		name           string
+		sinceID, maxID int64
+		confirmed      bool
+		wantErr        bool
+	}{
+		{"last week", weekAgo, 0, false, false},
+		{"last year", yearAgo, 0, false, true},
+		{"last year confirmed", yearAgo, 0, true, false},
+		{"a year ago for a week", yearAgo, idAt(now.AddDate(-1, 0, 7)), false, false},
+		{"only max", 0, weekAgo, false, false},
+		{"only an old max", 0, yearAgo, false, false},
+		{"backwards", weekAgo, yearAgo, true, true},
+		{"negative", -1, 0, true, true},
+	} {
+		if err := checkRange(tt.sinceID, tt.maxID, now, tt.confirmed); (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2022, 3, 4, 15, 30, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{"-5", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	} {
+		h := http.Header{}
+		if tt.header != "" {
+			h.Set("Retry-After", tt.header)
+		}
+		if got := retryAfter(h, now); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
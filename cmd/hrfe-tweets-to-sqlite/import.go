@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+// importJSONL reads newline-delimited Incident JSON from r and stores each
+// incident with the parser version, tags and communities it was exported
+// with. Malformed lines are logged and skipped. It returns how many
+// incidents were inserted, already present, and skipped as malformed.
+func importJSONL(db *sql.DB, r io.Reader) (inserted, existing, malformed int, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+
+		var in Incident
+		if err := json.Unmarshal(sc.Bytes(), &in); err != nil {
+			log.Printf("line %d: %v", line, err)
+			malformed++
+			continue
+		}
+		if err := validateImported(in); err != nil {
+			log.Printf("line %d: %v", line, err)
+			malformed++
+			continue
+		}
+
+		// Exports can be in local time, but times are stored in UTC so
+		// they sort and compare as text.
+		in.CreatedAt = in.CreatedAt.UTC()
+		if in.DispatchedAt != nil {
+			t := in.DispatchedAt.UTC()
+			in.DispatchedAt = &t
+		}
+
+		var ok bool
+		err := retryBusy(func() (err error) {
+			ok, err = importIncident(db, in)
+			return err
+		})
+		if err != nil {
+			return inserted, existing, malformed, fmt.Errorf("line %d: %w", line, err)
+		}
+		if ok {
+			inserted++
+		} else {
+			existing++
+		}
+	}
+	return inserted, existing, malformed, sc.Err()
+}
+
+// importIncident stores in along with its tags and communities, reporting
+// whether it was new. Each is its own transaction, so it can be retried.
+func importIncident(db *sql.DB, in Incident) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	ok, err := insertIncident(tx, in)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := insertJoined(tx, in); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func validateImported(in Incident) error {
+	switch {
+	case in.ID == "":
+		return errors.New("missing id")
+	case in.TweetID == 0:
+		return errors.New("missing tweet_id")
+	case in.CreatedAt.IsZero():
+		return errors.New("missing created_at")
+	}
+	return nil
+}
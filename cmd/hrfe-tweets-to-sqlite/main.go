@@ -2,199 +2,893 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
-	"html"
+	"io"
 	"log"
 	"os"
-	"regexp"
-	"sort"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/dghubble/go-twitter/twitter"
-	"github.com/dghubble/oauth1"
-	"golang.org/x/exp/maps"
 	_ "modernc.org/sqlite"
 )
 
+const screenName = "HRFE_Incidents"
+
 func main() {
-	db, err := sql.Open("sqlite", "data.db?_time_format=sqlite")
+	dbFlag := flag.String("db", "data.db", "sqlite database path; ${VAR} references are expanded from the environment")
+	strictSchema := flag.Bool("strict-schema", false, "fail if the database's tables don't match what this version expects, rather than upgrading them")
+	migrateDB := flag.Bool("migrate", false, "with -strict-schema, upgrade the database instead of failing")
+	mkdirDB := flag.Bool("mkdir", false, "create the -db directory if it doesn't exist")
+	maxAPICalls := flag.Int("max-api-calls", 0, "stop after this many timeline API calls, 0 for no limit")
+	backfillPages := flag.Int("backfill-pages", 0, "fetch at most this many pages of older tweets per run, 0 for no limit")
+	noBackfill := flag.Bool("no-backfill", false, "only fetch new tweets, skipping older ones entirely (-backfill-pages is then ignored)")
+	stats := flag.Bool("stats", false, "print a summary of stored incidents instead of fetching")
+	noColor := flag.Bool("no-color", false, "don't color and add emoji to incident types printed to a terminal")
+	noUnicode := flag.Bool("no-unicode", false, "use plain numbers instead of block characters in -stats output")
+	fromStdin := flag.Bool("stdin", false, "process newline-delimited JSON tweets, such as a twarc dump, from stdin instead of fetching")
+	seed := flag.Bool("seed", false, "store a small set of sample tweets, for trying out reports and exports without API access, instead of fetching")
+	importFile := flag.String("import-jsonl", "", "import newline-delimited incident JSON from this file (- for stdin) instead of fetching")
+	layout := flag.String("layout", "", "comma-separated `id,location,type,apparatus` line indexes for feeds laid out differently from HRFE's 0,1,2,3")
+	normalizeText := flag.Bool("normalize-text", false, "also store each tweet's text with whitespace tidied, in tweet_text_normalized")
+	stationPrefixes := flag.String("station-prefixes", "", "comma-separated prefixes marking stations in the apparatus line, for feeds not using HRFE's STN")
+	noCommunitySplit := flag.Bool("no-community-split", false, "store the whole location line as the location, leaving the community empty")
+	keepTypeHashtags := flag.Bool("keep-type-hashtags", false, "don't strip trailing #hashtags from incident types")
+	tagsFile := flag.String("tags", "", "CSV file of pattern,tag rules tagging incidents whose tweet text matches")
+	communitiesFile := flag.String("communities", "", "CSV file of alias,canonical community names to add to the built-in aliases")
+	digestDay := flag.String("digest", "", "print a Markdown digest of incidents on this `YYYY-MM-DD` day (in -tz) instead of fetching")
+	printCfg := flag.Bool("print-config", false, "print the effective settings as JSON and exit")
+	typesByMonth := flag.Int("types-by-month", 0, "print monthly counts of this many of the most common incident types instead of fetching")
+	busiestDays := flag.Int("busiest-days", 0, "print the N days with the most incidents instead of fetching")
+	last := flag.Int("last", 0, "print the N most recent incidents, such as 10, instead of fetching")
+	stationApparatus := flag.Bool("station-apparatus", false, "print which apparatus have and haven't responded alongside each station instead of fetching")
+	validateOnly := flag.Bool("validate-only", false, "check the database's integrity and for orphaned rows, exiting with status 4 on problems")
+	checkEnc := flag.Bool("check-encoding", false, "list stored incidents whose tweet text looks badly encoded and exit")
+	listStations := flag.Bool("list-stations", false, "print every station seen, with incident counts, instead of fetching")
+	listApparatus := flag.Bool("list-apparatus", false, "print every apparatus seen, with incident counts, instead of fetching")
+	countBy := flag.String("count-by", "", "print incident counts grouped by this field ("+strings.Join(countByFieldNames(), ", ")+") instead of fetching")
+	repeatMin := flag.Int("repeat-locations", 0, "print locations with more than N incidents instead of fetching")
+	repeatPeriod := flag.String("repeat-period", "", "limit -repeat-locations to a `YYYY-MM-DD..YYYY-MM-DD` range")
+	diffWith := flag.String("diff", "", "compare incidents with this other database and exit")
+	mergeFrom := flag.String("merge", "", "copy incidents from this other database into -db and exit")
+	periodA := flag.String("period-a", "", "with -period-b, compare incident counts by type between two `YYYY-MM-DD..YYYY-MM-DD` ranges instead of fetching")
+	periodB := flag.String("period-b", "", "see -period-a")
+	withBOM := flag.Bool("csv-bom", false, "start -csv output with a UTF-8 byte order mark, for Excel")
+	withTests := flag.Bool("include-tests", false, "count drills and test messages in reports")
+	asCSV := flag.Bool("csv", false, "write tabular reports as CSV")
+	userID := flag.Int64("user-id", 0, "fetch the timeline of the account with this numeric ID rather than by screen name")
+	resolveID := flag.Bool("resolve-user-id", false, "print the numeric ID of the account, for -user-id, and exit")
+	sample := flag.Int("sample", 0, "process just the newest N tweets (at most 200) from a single request, skipping the normal fetch loops")
+	sinceID := flag.Int64("since-id", 0, "only fetch tweets after this ID, instead of the normal fetch loops")
+	maxID := flag.Int64("max-id", 0, "only fetch tweets up to and including this ID, instead of the normal fetch loops")
+	checkpoint := flag.Int("checkpoint", 0, "commit after every N processed tweets rather than once per page")
+	progressBar := flag.Bool("progress-bar", false, "show backfill progress, as a bar on a terminal or periodic log lines otherwise")
+	reparseRows := flag.Bool("reparse", false, "parse stored incidents from an older parser version again and update them")
+	staleAfter := flag.Int("stale-runs", 0, "exit with status 3 if this many runs in a row, including this one, find no new tweets")
+	statsdAddr := flag.String("statsd", os.Getenv("STATSD_ADDR"), "send run metrics to the statsd server at this `host:port` (default $STATSD_ADDR)")
+	statsdPrefix := flag.String("statsd-prefix", "hrfe.", "prefix for statsd metric names")
+	dumpUnparsedErrs := flag.Bool("dump-unparsed", false, "print the tweets in processing_errors that fail to parse, with their errors, instead of fetching")
+	dumpLimit := flag.Int("dump-limit", 0, "print at most this many tweets with -dump-unparsed, 0 for all")
+	batch := flag.Int("batch", 1000, "rows per transaction for -reparse and -reprocess-errors, 0 for all in one")
+	reprocessErrs := flag.Bool("reprocess-errors", false, "try the tweets in processing_errors again, storing those that now parse")
+	emitSQL := flag.Bool("emit-sql", false, "log the SQL and arguments of each statement run while storing tweets")
+	benchParse := flag.Int("benchmark-parse", 0, "time parsing every stored tweet, list the N slowest and exit")
+	summaryFile := flag.String("summary-file", "", "write a JSON summary of each fetch to this file")
+	summaryAppend := flag.Bool("summary-append", false, "append to -summary-file rather than replacing it")
+	reparseAll := flag.Bool("reparse-all", false, "like -reparse, but for every stored incident, as after changing -tags or -communities")
+	quiet := flag.Bool("quiet", false, "only print errors")
+	maxParseFailures := flag.Int("max-parse-failures", 0, "exit with status 2 if more than this many tweets fail to parse")
+	tz := flag.String("tz", defaultTZ, "time zone for displayed times")
+	tzIn := flag.String("tz-in", defaultTZ, "time zone of -stdin timestamps that don't give one")
+	localTime := flag.Bool("local-time", false, "use -tz rather than UTC for times in -template and -export output")
+	byIncident := flag.Bool("by-incident", false, "export one merged row per incident ID rather than one per tweet")
+	redactCommunity := flag.String("redact-community", "", "when exporting, drop communities or coarsen them to their municipality (drop or coarsen)")
+	municipalitiesFile := flag.String("municipalities", "", "CSV file of community,municipality names for -redact-community coarsen")
+	gzipExport := flag.Bool("gzip", false, "gzip the output of -export or -template")
+	exportFormat := flag.String("export", "", "print stored incidents as jsonl (one object per line) or json-array instead of fetching")
+	repairTS := flag.Bool("repair-timestamps", false, "report incidents whose created_at isn't the tweet's UTC time, fixing them with -yes")
+	yes := flag.Bool("yes", false, "confirm changes made by -repair-timestamps, or large -since-id/-max-id ranges")
+	explainText := flag.String("explain", "", "show how this tweet text (- for stdin) parses and exit")
+	pruneEventsAge := flag.Duration("prune-events", 0, "delete incident_events older than this (e.g. 720h) and exit")
+	tmplText := flag.String("template", "", "print stored incidents using this text/template, one per line, instead of fetching")
+	flag.Parse()
+
+	dbPath, err := expandDBPath(*dbFlag)
+	if err != nil {
+		log.Fatalf("bad -db: %v", err)
+	}
+
+	if *printCfg {
+		if err := printConfig(os.Stdout, dbPath, screenName); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	parseOpts.keepTypeHashtags = *keepTypeHashtags
+	parseOpts.noCommunitySplit = *noCommunitySplit
+	csvBOM = *withBOM
+	useColor = !*noColor && !*quiet && isTerminal(os.Stdout)
+	includeTests = *withTests
+	storeNormalizedText = *normalizeText
+	if *stationPrefixes != "" {
+		p, err := parseStationPrefixes(*stationPrefixes)
+		if err != nil {
+			log.Fatalf("bad -station-prefixes: %v", err)
+		}
+		parseOpts.stationPrefixes = p
+	}
+	if *layout != "" {
+		l, err := parseLayout(*layout)
+		if err != nil {
+			log.Fatalf("bad -layout: %v", err)
+		}
+		parseOpts.layout = &l
+	}
+
+	if *communitiesFile != "" {
+		aliases, err := loadCommunityAliases(*communitiesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		communityAliases = withBuiltinAliases(aliases)
+	}
+
+	if *tagsFile != "" {
+		rules, err := loadTagRules(*tagsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tagRules = rules
+	}
+
+	if *explainText != "" {
+		text := *explainText
+		if text == "-" {
+			b, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatal(err)
+			}
+			text = strings.TrimSuffix(string(b), "\n")
+		}
+		explain(os.Stdout, text)
+		return
+	}
+
+	if *sinceID != 0 || *maxID != 0 {
+		if err := checkRange(*sinceID, *maxID, time.Now(), *yes); err != nil {
+			log.Fatalf("bad -since-id/-max-id: %v", err)
+		}
+	}
+
+	if err := checkDBDir(dbPath, *mkdirDB); err != nil {
+		log.Fatalf("bad -db: %v", err)
+	}
+
+	if err := setDisplayTZ(*tz); err != nil {
+		log.Fatalf("bad -tz: %v", err)
+	}
+	if err := setInputTZ(*tzIn); err != nil {
+		log.Fatalf("bad -tz-in: %v", err)
+	}
+
+	switch *exportFormat {
+	case "", exportJSONL, exportJSONArray:
+	default:
+		log.Fatalf("bad -export %q: want %s or %s", *exportFormat, exportJSONL, exportJSONArray)
+	}
+	if *exportFormat != "" && *tmplText != "" {
+		log.Fatal("-export and -template can't be used together")
+	}
+	switch *redactCommunity {
+	case "", redactDrop:
+	case redactCoarsen:
+		if *municipalitiesFile == "" {
+			log.Fatalf("-redact-community %s needs -municipalities", redactCoarsen)
+		}
+		m, err := loadCommunityAliases(*municipalitiesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		communityMunicipalities = m
+	default:
+		log.Fatalf("bad -redact-community %q: want %s or %s", *redactCommunity, redactDrop, redactCoarsen)
+	}
+	if *gzipExport && *exportFormat == "" && *tmplText == "" {
+		log.Fatal("-gzip only applies to -export and -template")
+	}
+
+	var tmpl *template.Template
+	if *tmplText != "" {
+		t, err := template.New("incident").Parse(*tmplText)
+		if err != nil {
+			log.Fatalf("bad -template: %v", err)
+		}
+		tmpl = t
+	}
+
+	db, err := sql.Open("sqlite", dbPath+"?_time_format=sqlite")
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
+	if *strictSchema && !*migrateDB {
+		if err := checkSchema(db); err != nil {
+			log.Fatalf("%s doesn't have the expected schema, run with -migrate to upgrade it: %v", dbPath, err)
+		}
+	}
 	if err := initDB(db); err != nil {
 		log.Fatal(err)
 	}
 
-	consumerKey, consumerSecret := os.Getenv("TWITTER_CONSUMER_KEY"), os.Getenv("TWITTER_CONSUMER_SECRET")
-	appToken, appSecret := os.Getenv("TWITTER_APP_TOKEN"), os.Getenv("TWITTER_APP_SECRET")
+	if *importFile != "" {
+		r := os.Stdin
+		if *importFile != "-" {
+			f, err := os.Open(*importFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			r = f
+		}
+		inserted, existing, malformed, err := importJSONL(db, r)
+		log.Printf("imported %d incidents, %d already present, %d malformed lines skipped", inserted, existing, malformed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *seed {
+		im := &importer{db: db, quiet: *quiet}
+		if err := im.process(seedTweets); err != nil {
+			log.Fatal(err)
+		}
+		im.logf("processed %d sample tweets, inserted %d", im.processed, im.inserted)
+		return
+	}
 
-	oaConfig := oauth1.NewConfig(consumerKey, consumerSecret)
-	oaToken := oauth1.NewToken(appToken, appSecret)
-	cl := oaConfig.Client(oauth1.NoContext, oaToken)
-	twc := twitter.NewClient(cl)
+	if *fromStdin {
+		im := &importer{db: db, quiet: *quiet, checkpoint: *checkpoint, emitSQL: *emitSQL}
+		malformed, err := readJSONTweets(im, os.Stdin)
+		im.logf("processed %d tweets, inserted %d, %d malformed lines skipped", im.processed, im.inserted, malformed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if im.parseFailures > *maxParseFailures {
+			log.Printf("%d tweets failed to parse", im.parseFailures)
+			db.Close()
+			os.Exit(exitPartial)
+		}
+		return
+	}
 
-	for {
-		max, err := maxTweetID(db)
+	if *pruneEventsAge > 0 {
+		n, err := pruneEvents(db, time.Now().Add(-*pruneEventsAge))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("pruned %d incident events", n)
+		return
+	}
+
+	if *diffWith != "" {
+		// Opening a missing database would quietly create an empty one.
+		if _, err := os.Stat(*diffWith); err != nil {
+			log.Fatal(err)
+		}
+		other, err := sql.Open("sqlite", *diffWith+"?_time_format=sqlite")
 		if err != nil {
 			log.Fatal(err)
 		}
+		defer other.Close()
+		if err := diffDBs(os.Stdout, db, other, dbPath, *diffWith); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-		tweets, err := tweetsSince(twc, max)
+	if *mergeFrom != "" {
+		if _, err := os.Stat(*mergeFrom); err != nil {
+			log.Fatal(err)
+		}
+		src, err := sql.Open("sqlite", *mergeFrom+"?_time_format=sqlite")
 		if err != nil {
 			log.Fatal(err)
 		}
-		if len(tweets) == 0 {
-			break
+		defer src.Close()
+		inserted, skipped, err := mergeDB(db, src)
+		if err != nil {
+			log.Fatal(err)
 		}
+		log.Printf("merged %s: inserted %d, skipped %d already present", *mergeFrom, inserted, skipped)
+		return
+	}
 
-		if err := process(db, tweets); err != nil {
+	if *repairTS {
+		n, err := repairTimestamps(os.Stdout, db, *yes)
+		if err != nil {
 			log.Fatal(err)
 		}
+		if *yes {
+			log.Printf("repaired %d incidents", n)
+		} else {
+			log.Printf("%d incidents need repair, run again with -yes to fix them", n)
+		}
+		return
 	}
 
-	for {
-		min, err := minTweetID(db)
+	if *reparseRows || *reparseAll {
+		// Tags are only rewritten with rules to rewrite them from, and
+		// communities with a -communities file or when reparsing everything.
+		tables := reparseTables{tags: *tagsFile != "", communities: *communitiesFile != "" || *reparseAll}
+		n, err := reparse(os.Stdout, db, *reparseAll, *batch, tables)
 		if err != nil {
 			log.Fatal(err)
 		}
+		log.Printf("reparsed %d incidents", n)
+		return
+	}
+
+	if *benchParse > 0 {
+		if err := benchmarkParse(os.Stdout, db, *benchParse); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *dumpUnparsedErrs {
+		if err := dumpUnparsed(os.Stdout, db, *dumpLimit); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-		tweets, err := tweetsUntil(twc, min)
+	if *reprocessErrs {
+		im := &importer{db: db, quiet: *quiet, checkpoint: *checkpoint, emitSQL: *emitSQL}
+		recovered, remaining, err := reprocessErrors(im, *batch)
 		if err != nil {
 			log.Fatal(err)
 		}
-		if len(tweets) == 0 {
-			break
+		im.logf("recovered %d tweets from processing_errors, %d still fail", recovered, remaining)
+		return
+	}
+
+	if *digestDay != "" {
+		if err := writeDigest(os.Stdout, db, *digestDay); err != nil {
+			log.Fatal(err)
 		}
+		return
+	}
 
-		if err := process(db, tweets); err != nil {
+	if *typesByMonth > 0 {
+		if err := printTypesByMonth(os.Stdout, db, *typesByMonth, *asCSV); err != nil {
 			log.Fatal(err)
 		}
+		return
 	}
-}
 
-func process(db *sql.DB, tweets []twitter.Tweet) error {
-	for _, tw := range tweets {
-		in, err := parse(tw.FullText)
+	if *busiestDays > 0 {
+		if err := printBusiestDays(os.Stdout, db, *busiestDays, *asCSV); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *periodA != "" || *periodB != "" {
+		a, err := parsePeriod(*periodA)
 		if err != nil {
-			return fmt.Errorf("tweet id=%v: %w", tw.ID, err)
+			log.Fatalf("bad -period-a: %v", err)
+		}
+		b, err := parsePeriod(*periodB)
+		if err != nil {
+			log.Fatalf("bad -period-b: %v", err)
+		}
+		if err := printComparePeriods(os.Stdout, db, a, b, *asCSV); err != nil {
+			log.Fatal(err)
 		}
+		return
+	}
 
-		createdAt, err := tw.CreatedAtTime()
+	if *validateOnly {
+		ok, err := checkIntegrity(os.Stdout, db)
 		if err != nil {
-			return fmt.Errorf("tweet id=%v: %w", tw.ID, err)
+			log.Fatal(err)
+		}
+		if !ok {
+			db.Close()
+			os.Exit(exitCorrupt)
 		}
+		return
+	}
 
-		if _, err := db.Exec(
-			"insert into incidents values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) on conflict (tweet_id) do nothing",
-			in.id, in.location, in.community, in.typ, strings.Join(in.apparatuses, " "), strings.Join(in.stations, " "), createdAt, tw.ID, tw.FullText, createdAt,
-		); err != nil {
-			return fmt.Errorf("tweet id=%v: %w", tw.ID, err)
+	if *checkEnc {
+		n, err := checkEncoding(os.Stdout, db, *asCSV)
+		if err != nil {
+			log.Fatal(err)
 		}
+		log.Printf("%d incidents with encoding issues", n)
+		return
+	}
 
-		fmt.Printf("in: %+v createdAt: %v\n", in, createdAt)
+	if *repeatMin > 0 {
+		var p *period
+		if *repeatPeriod != "" {
+			rp, err := parsePeriod(*repeatPeriod)
+			if err != nil {
+				log.Fatalf("bad -repeat-period: %v", err)
+			}
+			p = &rp
+		}
+		if err := printRepeatLocations(os.Stdout, db, *repeatMin, p, *asCSV); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *countBy != "" {
+		if err := printCountBy(os.Stdout, db, *countBy, *asCSV); err != nil {
+			log.Fatalf("-count-by: %v", err)
+		}
+		return
+	}
+
+	if *listStations || *listApparatus {
+		field := "station"
+		if *listApparatus {
+			field = "apparatus"
+		}
+		if err := printInventory(os.Stdout, db, field, *asCSV); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *stationApparatus {
+		if err := printStationApparatus(os.Stdout, db, *asCSV); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *last > 0 {
+		if err := printLast(os.Stdout, db, *last, *asCSV); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *stats {
+		if err := printStats(os.Stdout, db, time.Now(), !*noUnicode); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	return nil
-}
 
-func tweetsSince(twc *twitter.Client, id int64) ([]twitter.Tweet, error) {
-	params := &twitter.UserTimelineParams{
-		ScreenName: "HRFE_Incidents",
-		TweetMode:  "extended",
-		SinceID:    id,
+	exportOpts := exportOptions{localTime: *localTime, byIncident: *byIncident, redactCommunity: *redactCommunity}
+	if *exportFormat != "" || tmpl != nil {
+		err := writeExport(os.Stdout, *gzipExport, func(w io.Writer) error {
+			if *exportFormat != "" {
+				return exportJSON(w, db, *exportFormat == exportJSONArray, exportOpts)
+			}
+			return exportTemplate(w, db, tmpl, exportOpts)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	tweets, _, err := twc.Timelines.UserTimeline(params)
+
+	cl, err := httpClient()
 	if err != nil {
-		return nil, err
+		log.Fatal(err)
+	}
+	twc := twitter.NewClient(cl)
+
+	if *resolveID {
+		id, err := resolveUserID(twc, screenName)
+		if err != nil {
+			fetchFailed(err)
+		}
+		fmt.Printf("@%s has user ID %d, pass it with -user-id to keep fetching if the account is renamed\n", screenName, id)
+		return
+	}
+	accountUserID = *userID
+
+	budget := &apiBudget{max: *maxAPICalls}
+	im := &importer{db: db, quiet: *quiet, checkpoint: *checkpoint}
+	im.emitSQL = *emitSQL
+	if *progressBar && !*quiet {
+		im.progress = newProgress(os.Stdout)
+	}
+	switch {
+	case *sinceID != 0 || *maxID != 0:
+		fetchRange(twc, im, budget, *sinceID, *maxID)
+	case *sample > 0:
+		tweets, err := tweetsSample(twc, budget, *sample)
+		if err != nil {
+			fetchFailed(err)
+		}
+		if err := im.process(tweets); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		fetch(twc, im, budget, fetchOptions{backfillPages: *backfillPages, noBackfill: *noBackfill})
+	}
+	im.logf("made %d API calls", budget.calls)
+
+	m := runMetrics{processed: im.processed, inserted: im.inserted, parseFailures: im.parseFailures, apiCalls: budget.calls}
+	if *statsdAddr != "" {
+		if err := sendStatsd(*statsdAddr, *statsdPrefix, m); err != nil {
+			log.Printf("statsd: %v", err)
+		}
+	}
+
+	stale := 0
+	if *staleAfter > 0 {
+		max, err := maxTweetID(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := recordRun(db, max, *staleAfter); err != nil {
+			log.Fatal(err)
+		}
+		if stale, err = staleRuns(db); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *summaryFile != "" {
+		s, err := newRunSummary(db, m, stale)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeSummary(*summaryFile, s, *summaryAppend); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if im.parseFailures > *maxParseFailures {
+		log.Printf("%d tweets failed to parse", im.parseFailures)
+		db.Close()
+		os.Exit(exitPartial)
+	}
+	if stale >= *staleAfter && *staleAfter > 0 {
+		log.Printf("warning: no new tweets from @%s in the last %d runs, the feed or credentials may be broken", screenName, stale)
+		db.Close()
+		os.Exit(exitStale)
+	}
+}
+
+// Exit codes, besides the 1 from log.Fatal when something goes wrong.
+const (
+	exitPartial = 2 // the run finished but more tweets failed to parse than allowed
+	exitStale   = 3 // the run finished but no new tweets have been seen in -stale-runs runs
+	exitCorrupt = 4 // -validate-only found problems with the database
+)
+
+// importer stores parsed tweets and keeps track of how a run went.
+type importer struct {
+	db         *sql.DB
+	quiet      bool      // only log errors
+	checkpoint int       // commit after this many tweets, 0 for once per page
+	progress   *progress // backfill progress, nil for none
+	emitSQL    bool      // log statements run while storing tweets
+
+	processed     int
+	inserted      int
+	parseFailures int
+}
+
+// printTweets reports whether each stored tweet should be printed. A
+// progress bar takes their place on a terminal.
+func (im *importer) printTweets() bool {
+	return !im.quiet && (im.progress == nil || !im.progress.tty)
+}
+
+// logf logs informational messages unless im is quiet.
+func (im *importer) logf(format string, args ...any) {
+	if !im.quiet {
+		if im.progress != nil {
+			im.progress.done()
+		}
+		log.Printf(format, args...)
 	}
-	return tweets, nil
 }
 
-func tweetsUntil(twc *twitter.Client, id int64) ([]twitter.Tweet, error) {
-	params := &twitter.UserTimelineParams{
-		ScreenName: "HRFE_Incidents",
-		TweetMode:  "extended",
-		MaxID:      id - 1,
+// process stores tweets in order. It commits after every im.checkpoint
+// tweets, or once at the end when that's 0, so a crash loses at most one
+// checkpoint's worth of work and the next run picks up from there.
+func (im *importer) process(tweets []rawTweet) error {
+	size := im.checkpoint
+	if size <= 0 {
+		size = len(tweets)
+	}
+	for start := 0; start < len(tweets); start += size {
+		end := start + size
+		if end > len(tweets) {
+			end = len(tweets)
+		}
+		// A transaction that hits another connection's lock can't always
+		// carry on, so the whole batch is tried again in a new one.
+		processed, inserted, parseFailures := im.processed, im.inserted, im.parseFailures
+		if err := retryBusy(func() error {
+			im.processed, im.inserted, im.parseFailures = processed, inserted, parseFailures
+			return im.processBatch(tweets[start:end])
+		}); err != nil {
+			return err
+		}
 	}
-	tweets, _, err := twc.Timelines.UserTimeline(params)
+	return nil
+}
+
+// processBatch stores tweets in a single transaction.
+func (im *importer) processBatch(tweets []rawTweet) error {
+	tx, err := im.db.Begin()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, tw := range tweets {
+		if err := im.processTweet(tx, tw); err != nil {
+			var ie insertError
+			if errors.As(err, &ie) && !isBusy(ie.err) {
+				// Record the failure outside the transaction that's
+				// about to be rolled back.
+				tx.Rollback()
+				if rerr := recordError(im.db, tw, errorClassInsert, ie.err); rerr != nil {
+					log.Print(rerr)
+				}
+			}
+			return fmt.Errorf("tweet id=%v: %w", tw.ID, err)
+		}
 	}
-	return tweets, nil
+	return tx.Commit()
 }
 
-func maxTweetID(db *sql.DB) (int64, error) {
-	var max sql.NullInt64
-	if err := db.QueryRow("select max(tweet_id) from incidents").Scan(&max); err != nil {
-		return 0, err
+// insertError is returned by processTweet when storing the incident itself
+// failed.
+type insertError struct {
+	err error
+}
+
+func (e insertError) Error() string { return e.err.Error() }
+func (e insertError) Unwrap() error { return e.err }
+
+func (im *importer) processTweet(tx *sql.Tx, tw rawTweet) error {
+	im.processed++
+	var ex execer = tx
+	if im.emitSQL {
+		ex = sqlLogger{tx}
 	}
-	return max.Int64, nil
+	in, err := parse(tw.Text)
+	if err != nil {
+		im.parseFailures++
+		if err := recordError(ex, tw, errorClassParse, err); err != nil {
+			return err
+		}
+		return recordEvent(ex, tw.ID, "", eventParseError)
+	}
+
+	createdAt, err := tw.createdAt()
+	if err != nil {
+		if err := recordError(ex, tw, errorClassCreatedAt, err); err != nil {
+			return err
+		}
+		return recordEvent(ex, tw.ID, in.ID, eventCreatedAtError)
+	}
+	in.CreatedAt = createdAt
+	in.setDispatchedAt()
+	in.TweetID = tw.ID
+	in.TweetText = tw.Text
+	in.TweetLang = tw.Lang
+	in.TweetSource = tw.Source
+	in.Tags = tagsFor(tw.Text)
+
+	if err := enrich(&in); err != nil {
+		if err := recordError(ex, tw, errorClassEnrich, err); err != nil {
+			return err
+		}
+		return recordEvent(ex, tw.ID, in.ID, eventEnrichError)
+	}
+
+	// The account sometimes deletes and re-posts an incident tweet
+	// unchanged. Keep the first one and remember the re-post's ID.
+	var origID int64
+	err = tx.QueryRow("select tweet_id from incidents where id = ? and tweet_text = ? and tweet_id != ?", in.ID, tw.Text, tw.ID).Scan(&origID)
+	switch {
+	case err == nil:
+		if _, err := ex.Exec(
+			"insert into incident_alternate_tweets values (?, ?) on conflict (alternate_tweet_id) do nothing",
+			origID, tw.ID,
+		); err != nil {
+			return err
+		}
+		if im.printTweets() {
+			fmt.Printf("duplicate of tweet id=%v: %v\n", origID, tw.ID)
+		}
+		return recordEvent(ex, tw.ID, in.ID, eventDuplicate)
+	case err != sql.ErrNoRows:
+		return err
+	}
+
+	inserted, err := insertIncident(ex, in)
+	if err != nil {
+		return insertError{err}
+	}
+	event := eventExisting
+	if inserted {
+		im.inserted++
+		event = eventInserted
+	}
+	if err := recordEvent(ex, tw.ID, in.ID, event); err != nil {
+		return err
+	}
+
+	for _, m := range tw.Media {
+		if _, err := ex.Exec(
+			"insert into incident_media values (?, ?, ?, ?, ?) on conflict (tweet_id, media_id) do nothing",
+			in.ID, tw.ID, m.ID, m.Type, m.URL,
+		); err != nil {
+			return fmt.Errorf("media id=%v: %w", m.ID, err)
+		}
+	}
+
+	if err := insertJoined(ex, in); err != nil {
+		return err
+	}
+
+	if im.printTweets() {
+		fmt.Println(styleByType(in.Type, fmt.Sprintf("in: %+v createdAt: %v", in, createdAt)))
+	}
+	return nil
 }
 
-func minTweetID(db *sql.DB) (int64, error) {
-	var min sql.NullInt64
-	if err := db.QueryRow("select min(tweet_id) from incidents").Scan(&min); err != nil {
+// Outcomes of seeing a tweet, recorded in incident_events.
+const (
+	eventInserted       = "inserted"
+	eventExisting       = "existing"  // already stored, nothing changed
+	eventDuplicate      = "duplicate" // re-post of a stored incident tweet
+	eventParseError     = "parse_error"
+	eventCreatedAtError = "created_at_error"
+	eventEnrichError    = "enrich_error"
+)
+
+// recordEvent appends to the incident_events audit log. Only inserted
+// tweets change the stored incidents.
+func recordEvent(db execer, tweetID int64, incidentID, outcome string) error {
+	_, err := db.Exec(
+		"insert into incident_events values (?, ?, ?, ?, ?)",
+		tweetID, incidentID, time.Now().UTC(), outcome, outcome == eventInserted,
+	)
+	return err
+}
+
+// pruneEvents deletes incident_events older than before, returning how many
+// were removed.
+func pruneEvents(db *sql.DB, before time.Time) (int64, error) {
+	res, err := db.Exec("delete from incident_events where seen_at < ?", before.UTC())
+	if err != nil {
 		return 0, err
 	}
-	return min.Int64, nil
+	return res.RowsAffected()
 }
 
-var multiSpaceRe = regexp.MustCompile(`\s{3,}`)
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// sqlLogger logs each statement and its arguments before executing it.
+type sqlLogger struct {
+	execer
+}
 
-type incident struct {
-	id          string
-	location    string
-	community   string
-	typ         string
-	apparatuses []string
-	stations    []string
+func (l sqlLogger) Exec(query string, args ...any) (sql.Result, error) {
+	log.Printf("sql: %s args=%#v", query, args)
+	return l.execer.Exec(query, args...)
 }
 
-func parse(s string) (incident, error) {
-	s = html.UnescapeString(s)
-	lines := strings.Split(s, "\n")
-	if len(lines) != 4 {
-		return incident{}, fmt.Errorf("bad tweet with %v lines", len(lines))
+// insertIncident stores in, reporting whether it was new. Incidents whose
+// tweet is already stored are left as they are.
+func insertIncident(db execer, in Incident) (bool, error) {
+	var normalized any
+	if storeNormalizedText {
+		normalized = normalizeTweetText(in.TweetText)
 	}
-	loc := lines[1]
-	loc = multiSpaceRe.ReplaceAllString(loc, "  ")
-	var comm string
-	locParts := strings.Split(loc, "  ")
-	if len(locParts) == 2 {
-		loc = strings.TrimSpace(locParts[0])
-		comm = strings.TrimSpace(locParts[1])
+	// Stored as null when unknown, so it's reparsed.
+	var version any
+	if in.ParserVersion != 0 {
+		version = in.ParserVersion
 	}
-
-	in := incident{
-		id:        lines[0],
-		location:  loc,
-		community: comm,
-		typ:       lines[2],
+	res, err := db.Exec(
+		"insert into incidents (id, location, community, type, apparatuses, station, created_at, tweet_id, tweet_text, tweet_created_at, apparatus_count, station_count, type_inferred, parser_version, tweet_text_normalized, disposition, dispatched_at, is_test, tweet_lang, tweet_source) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) on conflict (tweet_id) do nothing",
+		in.ID, in.Location, in.Community, in.Type, strings.Join(in.Apparatuses, " "), strings.Join(in.Stations, " "), in.CreatedAt, in.TweetID, in.TweetText, in.CreatedAt, len(in.Apparatuses), len(in.Stations), in.TypeInferred, version, normalized, in.Disposition, in.DispatchedAt, in.IsTest, in.TweetLang, in.TweetSource,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
 	}
+	return n > 0, nil
+}
 
-	apparatuses := make(map[string]struct{})
-	stations := make(map[string]struct{})
-	for _, f := range strings.Fields(lines[3]) {
-		if strings.HasPrefix(f, "STN") {
-			stations[f] = struct{}{}
-			continue
+// insertJoined stores the tags and communities of in, an incident already
+// stored.
+func insertJoined(ex execer, in Incident) error {
+	for _, t := range in.Tags {
+		if _, err := ex.Exec(
+			"insert into incident_tags values (?, ?) on conflict (tweet_id, tag) do nothing",
+			in.TweetID, t,
+		); err != nil {
+			return fmt.Errorf("tag %q: %w", t, err)
+		}
+	}
+	for _, c := range in.Communities {
+		if _, err := ex.Exec(
+			"insert into incident_communities values (?, ?) on conflict (tweet_id, community) do nothing",
+			in.TweetID, c,
+		); err != nil {
+			return fmt.Errorf("community %q: %w", c, err)
 		}
-		apparatuses[f] = struct{}{}
 	}
+	return nil
+}
+
+// Classes of errors recorded in processing_errors.
+const (
+	errorClassParse     = "parse"
+	errorClassCreatedAt = "created_at"
+	errorClassInsert    = "insert"
+	errorClassEnrich    = "enrich"
+)
+
+// recordError notes that tw could not be processed. Tweets with parse,
+// created_at or enrich errors are then skipped; insert errors still stop
+// the run, since they usually mean something is wrong with the database
+// rather than the tweet.
+func recordError(db execer, tw rawTweet, class string, err error) error {
+	log.Printf("tweet id=%v: %s error: %v", tw.ID, class, err)
+	if _, err := db.Exec(
+		"insert into processing_errors values (?, ?, ?, ?, ?, ?)",
+		tw.ID, class, err.Error(), tw.Text, tw.CreatedRaw, time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("tweet id=%v: recording %s error: %w", tw.ID, class, err)
+	}
+	return nil
+}
 
-	in.apparatuses = maps.Keys(apparatuses)
-	sort.Strings(in.apparatuses)
+// seenTweetIDs selects the IDs of every tweet already handled, used to find
+// where fetching should resume.
+const seenTweetIDs = "select tweet_id from incidents union all select alternate_tweet_id from incident_alternate_tweets union all select tweet_id from processing_errors where class != '" + errorClassInsert + "'"
 
-	in.stations = maps.Keys(stations)
-	sort.Strings(in.stations)
+func seenTweetCount(db *sql.DB) (int, error) {
+	var n int
+	if err := db.QueryRow("select count(*) from (" + seenTweetIDs + ")").Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
 
-	return in, nil
+func maxTweetID(db *sql.DB) (int64, error) {
+	var max sql.NullInt64
+	if err := db.QueryRow("select max(tweet_id) from (" + seenTweetIDs + ")").Scan(&max); err != nil {
+		return 0, err
+	}
+	return max.Int64, nil
 }
 
-func initDB(db *sql.DB) error {
-	if _, err := db.Exec("create table if not exists incidents (id text, location text, community text, type text, apparatuses text, station text, created_at datetime, tweet_id integer UNIQUE, tweet_text text, tweet_created_at datetime)"); err != nil {
-		return err
+func minTweetID(db *sql.DB) (int64, error) {
+	var min sql.NullInt64
+	if err := db.QueryRow("select min(tweet_id) from (" + seenTweetIDs + ")").Scan(&min); err != nil {
+		return 0, err
 	}
-	return nil
+	return min.Int64, nil
 }
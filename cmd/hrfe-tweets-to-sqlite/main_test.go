@@ -0,0 +1,415 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// newTestDB returns an initialized in-memory database that's closed when the
+// test ends.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:?_time_format=sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: gets its own empty database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := initDB(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+var testTime = time.Date(2022, 3, 4, 15, 30, 0, 0, time.UTC)
+
+// testTweet returns a tweet with the given ID and text, posted at testTime.
+func testTweet(id int64, text string) rawTweet {
+	return rawTweet{ID: id, Text: text, Created: testTime, CreatedRaw: testTime.Format(time.RubyDate)}
+}
+
+// countRows returns the number of rows in table.
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("select count(*) from " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestInitDBTwice(t *testing.T) {
+	db := newTestDB(t)
+	if err := initDB(db); err != nil {
+		t.Fatalf("second initDB: %v", err)
+	}
+	if err := checkSchema(db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckSchemaMismatch(t *testing.T) {
+	db := newTestDB(t)
+	for _, q := range []string{
+		"alter table incidents rename column disposition to outcome",
+		"drop table incident_media",
+		"alter table incidents add column extra text",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	err := checkSchema(db)
+	if err == nil {
+		t.Fatal("got no error, want the mismatches reported")
+	}
+	for _, want := range []string{"missing column incidents.disposition", "missing table incident_media"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %s", err, want)
+		}
+	}
+	// Extra columns are fine.
+	if strings.Contains(err.Error(), "extra") || strings.Contains(err.Error(), "outcome") {
+		t.Errorf("error %q mentions an extra column", err)
+	}
+}
+
+func TestExpandDBPath(t *testing.T) {
+	t.Setenv("HRFE_DATA", "/var/lib/hrfe")
+	// Set, then unset, so it's restored if it was set before.
+	t.Setenv("HRFE_UNSET", "")
+	os.Unsetenv("HRFE_UNSET")
+
+	if got, err := expandDBPath("$HRFE_DATA/incidents.db"); err != nil || got != "/var/lib/hrfe/incidents.db" {
+		t.Errorf("got %q, %v, want /var/lib/hrfe/incidents.db", got, err)
+	}
+	if got, err := expandDBPath("${HRFE_DATA}.db"); err != nil || got != "/var/lib/hrfe.db" {
+		t.Errorf("got %q, %v, want /var/lib/hrfe.db", got, err)
+	}
+	// Rather than quietly writing to /incidents.db.
+	_, err := expandDBPath("$HRFE_UNSET/incidents.db")
+	if err == nil || !strings.Contains(err.Error(), "HRFE_UNSET") {
+		t.Errorf("got %v, want an error naming HRFE_UNSET", err)
+	}
+}
+
+func TestMinMaxTweetID(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, f := range []func(*sql.DB) (int64, error){minTweetID, maxTweetID} {
+		if id, err := f(db); err != nil || id != 0 {
+			t.Fatalf("empty database: got %v, %v, want 0", id, err)
+		}
+	}
+
+	for _, id := range []int64{20, 10, 30} {
+		in := Incident{ID: "22-1", Location: "1 MAIN ST", TweetID: id, CreatedAt: testTime}
+		if _, err := insertIncident(db, in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Tweets that failed to parse count as seen too.
+	if err := recordError(db, testTweet(5, "bad"), errorClassParse, errors.New("bad tweet")); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := minTweetID(db); err != nil || id != 5 {
+		t.Errorf("minTweetID = %v, %v, want 5", id, err)
+	}
+	if id, err := maxTweetID(db); err != nil || id != 30 {
+		t.Errorf("maxTweetID = %v, %v, want 30", id, err)
+	}
+}
+
+func TestInsertIncidentConflict(t *testing.T) {
+	db := newTestDB(t)
+
+	in := Incident{ID: "22-1", Location: "1 MAIN ST", Type: "FIRE", TweetID: 10, CreatedAt: testTime}
+	inserted, err := insertIncident(db, in)
+	if err != nil || !inserted {
+		t.Fatalf("first insert = %v, %v, want true", inserted, err)
+	}
+
+	in.Type = "MEDICAL"
+	inserted, err = insertIncident(db, in)
+	if err != nil || inserted {
+		t.Fatalf("second insert = %v, %v, want false", inserted, err)
+	}
+
+	if n := countRows(t, db, "incidents"); n != 1 {
+		t.Fatalf("got %d rows, want 1", n)
+	}
+	var typ string
+	if err := db.QueryRow("select type from incidents where tweet_id = 10").Scan(&typ); err != nil {
+		t.Fatal(err)
+	}
+	if typ != "FIRE" {
+		t.Errorf("type = %q, want the first insert's FIRE", typ)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+
+	tweets := []rawTweet{
+		testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1 STN2"),
+		testTweet(11, "not an incident"),
+	}
+	if err := im.process(tweets); err != nil {
+		t.Fatal(err)
+	}
+	if im.processed != 2 || im.inserted != 1 || im.parseFailures != 1 {
+		t.Errorf("processed %d, inserted %d, %d parse failures, want 2, 1, 1", im.processed, im.inserted, im.parseFailures)
+	}
+	if n := countRows(t, db, "processing_errors"); n != 1 {
+		t.Errorf("got %d processing errors, want 1", n)
+	}
+
+	var ins []Incident
+	if err := eachIncident(db, "", nil, func(in Incident) error {
+		ins = append(ins, in)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ins) != 1 {
+		t.Fatalf("got %d incidents, want 1", len(ins))
+	}
+	in := ins[0]
+	if in.ID != "22-1" || in.Location != "1 MAIN ST" || in.Type != "FIRE" || !in.CreatedAt.Equal(testTime) {
+		t.Errorf("got %+v", in)
+	}
+}
+
+func TestProcessCounts(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1 L4 E1 STN2 STN2 STN3"),
+		testTweet(11, "22-2\n2 ELM ST  HALIFAX\nMEDICAL\nSTN5"),
+		testTweet(12, "22-3\n3 OAK ST  BEDFORD\nMVC\nE5 E5"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var ins []Incident
+	if err := eachIncident(db, "", nil, func(in Incident) error {
+		ins = append(ins, in)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range ins {
+		var apparatusCount, stationCount int
+		if err := db.QueryRow("select apparatus_count, station_count from incidents where tweet_id = ?", in.TweetID).Scan(&apparatusCount, &stationCount); err != nil {
+			t.Fatal(err)
+		}
+		if apparatusCount != len(in.Apparatuses) || stationCount != len(in.Stations) {
+			t.Errorf("tweet id=%v: counts %d, %d for %q and %q", in.TweetID, apparatusCount, stationCount, in.Apparatuses, in.Stations)
+		}
+	}
+
+	// Repeats are counted once, and the migration computing the counts
+	// for older rows agrees.
+	var got string
+	if err := db.QueryRow("select group_concat(apparatus_count || ' ' || station_count, ', ') from (select * from incidents order by tweet_id)").Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "2 2, 0 1, 1 0"; got != want {
+		t.Errorf("counts %q, want %q", got, want)
+	}
+	var mismatched int
+	if err := db.QueryRow("select count(*) from incidents where apparatus_count != " + wordCount("apparatuses") + " or station_count != " + wordCount("station")).Scan(&mismatched); err != nil {
+		t.Fatal(err)
+	}
+	if mismatched != 0 {
+		t.Errorf("%d rows have counts other than the migration's", mismatched)
+	}
+}
+
+func TestProcessLangSource(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	const source = `<a href="https://about.twitter.com/products/tweetdeck" rel="nofollow">TweetDeck</a>`
+	tweets := fromTwitter([]twitter.Tweet{
+		{ID: 10, FullText: "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1", CreatedAt: testTime.Format(time.RubyDate), Lang: "en", Source: source},
+		// Missing both, as from older exports.
+		{ID: 11, FullText: "22-2\n2 ELM ST  HALIFAX\nMEDICAL\nE3", CreatedAt: testTime.Format(time.RubyDate)},
+	})
+	if err := im.process(tweets); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[int64][2]string{
+		10: {"en", source},
+		11: {"", ""},
+	} {
+		var lang, src string
+		if err := db.QueryRow("select tweet_lang, tweet_source from incidents where tweet_id = ?", id).Scan(&lang, &src); err != nil {
+			t.Fatal(err)
+		}
+		if got := [2]string{lang, src}; got != want {
+			t.Errorf("tweet id=%v: stored %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestProcessRepostedTweet(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+
+	const text = "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1"
+	if err := im.process([]rawTweet{testTweet(10, text), testTweet(11, text)}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "incidents"); n != 1 {
+		t.Errorf("got %d incidents, want 1", n)
+	}
+	var orig, alt int64
+	if err := db.QueryRow("select tweet_id, alternate_tweet_id from incident_alternate_tweets").Scan(&orig, &alt); err != nil {
+		t.Fatal(err)
+	}
+	if orig != 10 || alt != 11 {
+		t.Errorf("alternate tweet %d of %d, want 11 of 10", alt, orig)
+	}
+	// The repost still counts as seen, so fetching resumes after it.
+	if id, err := maxTweetID(db); err != nil || id != 11 {
+		t.Errorf("maxTweetID = %v, %v, want 11", id, err)
+	}
+}
+
+func TestProcessCheckpointResume(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true, checkpoint: 2}
+
+	var tweets []rawTweet
+	for i := int64(1); i <= 5; i++ {
+		tweets = append(tweets, testTweet(i, fmt.Sprintf("22-%d\n%d MAIN ST  DARTMOUTH\nFIRE\nE1", i, i)))
+	}
+	// Make the third tweet fail part way through storing it, as if the run
+	// crashed there.
+	tweets[2].Media = []rawMedia{{ID: 1, Type: "photo", URL: "https://pbs.twimg.com/media/a.jpg"}}
+	if _, err := db.Exec("drop table incident_media"); err != nil {
+		t.Fatal(err)
+	}
+	if err := im.process(tweets); err == nil {
+		t.Fatal("process succeeded, want the induced failure")
+	}
+
+	// The first checkpoint's worth was kept and nothing after it.
+	if id, err := maxTweetID(db); err != nil || id != 2 {
+		t.Fatalf("maxTweetID after the crash = %v, %v, want 2", id, err)
+	}
+
+	// The next run resumes after the newest stored tweet.
+	if err := initDB(db); err != nil {
+		t.Fatal(err)
+	}
+	im = &importer{db: db, quiet: true, checkpoint: 2}
+	if err := im.process(tweets[2:]); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "incidents"); n != 5 {
+		t.Errorf("got %d incidents, want 5", n)
+	}
+	if n := countRows(t, db, "incident_events"); n != 5 {
+		t.Errorf("got %d events, want one per tweet", n)
+	}
+	if n := countRows(t, db, "incident_media"); n != 1 {
+		t.Errorf("got %d media rows, want 1", n)
+	}
+}
+
+func TestProcessEvents(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	tw := testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1")
+
+	for i, want := range []string{eventInserted, eventExisting, eventExisting} {
+		if err := im.process([]rawTweet{tw}); err != nil {
+			t.Fatal(err)
+		}
+		if n := countRows(t, db, "incident_events"); n != i+1 {
+			t.Fatalf("after seeing the tweet %d times got %d events", i+1, n)
+		}
+		var (
+			outcome string
+			changed bool
+		)
+		if err := db.QueryRow("select outcome, changed from incident_events where tweet_id = 10 order by rowid desc limit 1").Scan(&outcome, &changed); err != nil {
+			t.Fatal(err)
+		}
+		if outcome != want || changed != (i == 0) {
+			t.Errorf("seeing the tweet %d times: event %q, changed %v, want %q", i+1, outcome, changed, want)
+		}
+	}
+
+	if n, err := pruneEvents(db, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("pruning older events = %v, %v, want none pruned", n, err)
+	}
+	if n, err := pruneEvents(db, time.Now().Add(time.Hour)); err != nil || n != 3 {
+		t.Errorf("pruning all events = %v, %v, want 3", n, err)
+	}
+}
+
+func TestProcessBusy(t *testing.T) {
+	path := t.TempDir() + "/data.db"
+	db, err := sql.Open("sqlite", path+"?_time_format=sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := initDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process holds the write lock for a while.
+	other, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	lock, err := other.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock.Exec("insert into runs values (datetime('now'), 0)"); err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(2 * busyBackoff)
+		lock.Rollback()
+		close(released)
+	}()
+
+	im := &importer{db: db, quiet: true}
+	tweets := []rawTweet{
+		// The first write is recording this one's parse error.
+		testTweet(10, "not an incident"),
+		testTweet(11, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1"),
+	}
+	if err := im.process(tweets); err != nil {
+		t.Fatal(err)
+	}
+	<-released
+
+	if im.processed != 2 || im.inserted != 1 || im.parseFailures != 1 {
+		t.Errorf("processed %d, inserted %d, %d parse failures, want 2, 1, 1", im.processed, im.inserted, im.parseFailures)
+	}
+	if n := countRows(t, db, "incidents"); n != 1 {
+		t.Errorf("got %d incidents, want 1", n)
+	}
+	if n := countRows(t, db, "processing_errors"); n != 1 {
+		t.Errorf("got %d processing errors, want 1", n)
+	}
+}
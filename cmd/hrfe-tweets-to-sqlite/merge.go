@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// mergeTables are the tables mergeDB copies. Each has a unique constraint
+// that makes copying a row dst already has a no-op.
+var mergeTables = []string{"incidents", "incident_media", "incident_alternate_tweets", "incident_communities", "incident_tags"}
+
+// mergeDerived computes incidents columns that a source database from before
+// they were added doesn't have. Other missing columns get dst's defaults, so
+// a missing parser_version leaves the incident to be reparsed.
+var mergeDerived = map[string]string{
+	"apparatus_count": wordCount("apparatuses"),
+	"station_count":   wordCount("station"),
+}
+
+// mergeDB copies every incident in src, with its media, alternate tweets,
+// communities and tags, into dst in a single transaction. Incidents whose
+// tweet is already in dst are skipped, as when fetching. src may be from an
+// older version, in which case only the columns it has are copied. It
+// returns how many incidents were inserted and skipped.
+func mergeDB(dst, src *sql.DB) (inserted, skipped int, err error) {
+	srcColumns, err := tableColumns(src)
+	if err != nil {
+		return 0, 0, err
+	}
+	dstColumns, err := tableColumns(dst)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := dst.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range mergeTables {
+		if srcColumns[table] == nil {
+			continue
+		}
+		var columns, exprs []string
+		for _, c := range sortedKeys(dstColumns[table]) {
+			if _, ok := srcColumns[table][c]; ok {
+				columns, exprs = append(columns, c), append(exprs, c)
+			} else if expr, ok := mergeDerived[c]; ok && table == "incidents" {
+				columns, exprs = append(columns, c), append(exprs, expr)
+			}
+		}
+		n, read, err := copyRows(tx, src, table, columns, exprs)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", table, err)
+		}
+		if table == "incidents" {
+			inserted, skipped = n, read-n
+		}
+	}
+	return inserted, skipped, tx.Commit()
+}
+
+// copyRows inserts the values of exprs for each row of table in src into
+// the same table's columns in tx, leaving rows that conflict with one
+// already there. It returns how many rows were inserted out of those read.
+func copyRows(tx *sql.Tx, src *sql.DB, table string, columns, exprs []string) (inserted, read int, err error) {
+	rows, err := src.Query(fmt.Sprintf("select %s from %s", strings.Join(exprs, ", "), table))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	q := fmt.Sprintf("insert into %s (%s) values (%s) on conflict do nothing",
+		table, strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+	vals := make([]any, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, 0, err
+		}
+		res, err := tx.Exec(q, vals...)
+		if err != nil {
+			return 0, 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, 0, err
+		}
+		inserted += int(n)
+		read++
+	}
+	return inserted, read, rows.Err()
+}
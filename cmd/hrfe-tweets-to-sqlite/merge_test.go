@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestMergeDB(t *testing.T) {
+	dst, src := newTestDB(t), newTestDB(t)
+	im := &importer{db: dst, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(1, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1"),
+		testTweet(2, "22-2\n2 MAIN ST  DARTMOUTH\nFIRE\nE1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	photo := testTweet(3, "22-3\n3 MAIN ST  DARTMOUTH/COLE HARBOUR\nFIRE\nE1 L4")
+	photo.Media = []rawMedia{{ID: 99, Type: "photo", URL: "https://pbs.twimg.com/media/a.jpg"}}
+	repost := testTweet(4, photo.Text)
+	im = &importer{db: src, quiet: true}
+	if err := im.process([]rawTweet{testTweet(2, "22-2\n2 MAIN ST  DARTMOUTH\nFIRE\nE1"), photo, repost}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Exec("insert into incident_tags values (3, 'ladder')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Exec("update incidents set parser_version = 1 where tweet_id = 3"); err != nil {
+		t.Fatal(err)
+	}
+
+	inserted, skipped, err := mergeDB(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 1 || skipped != 1 {
+		t.Errorf("inserted %d, skipped %d, want 1, 1", inserted, skipped)
+	}
+	for table, want := range map[string]int{
+		"incidents":                 3,
+		"incident_media":            1,
+		"incident_alternate_tweets": 1,
+		"incident_communities":      2,
+		"incident_tags":             1,
+	} {
+		if n := countRows(t, dst, table); n != want {
+			t.Errorf("got %d %s rows, want %d", n, table, want)
+		}
+	}
+	var version int
+	if err := dst.QueryRow("select parser_version from incidents where tweet_id = 3").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("parser_version = %d, want the source's 1", version)
+	}
+
+	// Merging again changes nothing.
+	inserted, skipped, err = mergeDB(dst, src)
+	if err != nil || inserted != 0 || skipped != 2 {
+		t.Errorf("second merge = %d, %d, %v, want 0, 2", inserted, skipped, err)
+	}
+	if n := countRows(t, dst, "incident_communities"); n != 2 {
+		t.Errorf("got %d communities after merging again, want 2", n)
+	}
+}
+
+func TestMergeOldDB(t *testing.T) {
+	dst := newTestDB(t)
+	src, err := sql.Open("sqlite", ":memory:?_time_format=sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	src.SetMaxOpenConns(1)
+	// As created by the first version, before any migrations.
+	if _, err := src.Exec("create table incidents (id text, location text, community text, type text, apparatuses text, station text, created_at datetime, tweet_id integer UNIQUE, tweet_text text, tweet_created_at datetime)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Exec("insert into incidents values ('22-1', '1 MAIN ST', 'DARTMOUTH', 'FIRE', 'E1 L4', 'STN2', ?, 1, 'text', ?)", testTime, testTime); err != nil {
+		t.Fatal(err)
+	}
+
+	inserted, skipped, err := mergeDB(dst, src)
+	if err != nil || inserted != 1 || skipped != 0 {
+		t.Fatalf("merge = %d, %d, %v, want 1, 0", inserted, skipped, err)
+	}
+	var (
+		apparatusCount, stationCount int
+		version                      sql.NullInt64
+	)
+	if err := dst.QueryRow("select apparatus_count, station_count, parser_version from incidents where tweet_id = 1").Scan(&apparatusCount, &stationCount, &version); err != nil {
+		t.Fatal(err)
+	}
+	if apparatusCount != 2 || stationCount != 1 || version.Valid {
+		t.Errorf("got counts %d, %d and version %v, want 2, 1 and none", apparatusCount, stationCount, version)
+	}
+	if err := eachIncident(dst, "", nil, func(in Incident) error {
+		if in.ID != "22-1" || !in.CreatedAt.Equal(testTime) {
+			t.Errorf("got %+v", in)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
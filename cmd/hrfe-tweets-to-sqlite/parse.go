@@ -0,0 +1,490 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+)
+
+// parserVersion is stored with each incident so rows parsed by an older
+// parser can be found and parsed again with -reparse. Bump it whenever a
+// change to parse would give different results for stored tweets.
+const parserVersion = 11
+
+// parseOptions adjust parse for feeds that format their tweets differently
+// from HRFE's. The zero value is right for HRFE.
+type parseOptions struct {
+	keepTypeHashtags bool        // don't strip trailing #hashtags from the type line
+	noCommunitySplit bool        // keep the whole location line as the location, with no community
+	layout           *lineLayout // which line holds what, nil for HRFE's
+	stationPrefixes  []string    // apparatus line words starting with these are stations, nil for HRFE's
+}
+
+// hrfeStationPrefixes mark stations in HRFE's apparatus lines, as in "STN5".
+var hrfeStationPrefixes = []string{"STN"}
+
+// isStation reports whether f from the apparatus line is a station rather
+// than an apparatus.
+func (o parseOptions) isStation(f string) bool {
+	prefixes := o.stationPrefixes
+	if prefixes == nil {
+		prefixes = hrfeStationPrefixes
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(f, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStationPrefixes parses a comma-separated list of station prefixes.
+// An empty prefix would make every word a station, so it's an error.
+func parseStationPrefixes(s string) ([]string, error) {
+	var prefixes []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			return nil, fmt.Errorf("empty prefix in %q", s)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// lines returns the layout parse should use.
+func (o parseOptions) lines() lineLayout {
+	if o.layout == nil {
+		return hrfeLayout
+	}
+	return *o.layout
+}
+
+// lineLayout gives the 0-based index of each line of an incident tweet.
+type lineLayout struct {
+	id, location, typ, apparatus int
+}
+
+// hrfeLayout is how HRFE lays out its tweets.
+var hrfeLayout = lineLayout{id: 0, location: 1, typ: 2, apparatus: 3}
+
+// count is the number of lines a tweet with this layout has.
+func (l lineLayout) count() int {
+	n := 0
+	for _, i := range []int{l.id, l.location, l.typ, l.apparatus} {
+		if i+1 > n {
+			n = i + 1
+		}
+	}
+	return n
+}
+
+// parseLayout parses a layout given as comma-separated line indexes in the
+// order id,location,type,apparatus, such as HRFE's "0,1,2,3".
+func parseLayout(s string) (lineLayout, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return lineLayout{}, fmt.Errorf("want 4 line indexes (id,location,type,apparatus), got %q", s)
+	}
+	var idx [4]int
+	seen := make(map[int]bool)
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 0 {
+			return lineLayout{}, fmt.Errorf("bad line index %q", p)
+		}
+		if seen[n] {
+			return lineLayout{}, fmt.Errorf("line %d used twice", n)
+		}
+		seen[n] = true
+		idx[i] = n
+	}
+	return lineLayout{id: idx[0], location: idx[1], typ: idx[2], apparatus: idx[3]}, nil
+}
+
+// parseOpts are the options used by parse, set from flags.
+var parseOpts parseOptions
+
+// Incident is a single parsed incident tweet.
+type Incident struct {
+	ID          string    `json:"id"`
+	Location    string    `json:"location"`
+	Community   string    `json:"community"`
+	Type        string    `json:"type"`
+	Apparatuses []string  `json:"apparatuses"`
+	Stations    []string  `json:"stations"`
+	CreatedAt   time.Time `json:"created_at"`
+	TweetID     int64     `json:"tweet_id"`
+	TweetText   string    `json:"tweet_text"`
+
+	// DispatchedAt is when the incident was dispatched, for tweets giving a
+	// time as well as being posted. It's set from DispatchClock when
+	// storing, since parse doesn't know the date.
+	DispatchedAt  *time.Time `json:"dispatched_at,omitempty"`
+	DispatchClock string     `json:"-"` // "HH:MM" from the tweet
+
+	// Disposition is the outcome some tweets add after the type, as in
+	// "MEDICAL - Transported", upper-cased. It's empty when there's none.
+	Disposition string `json:"disposition,omitempty"`
+
+	// Communities lists every community for incidents on a boundary, as in
+	// "DARTMOUTH/COLE HARBOUR". Community is then the first of them. They
+	// are stored in incident_communities.
+	Communities []string `json:"communities,omitempty"`
+
+	// TweetURL links to the tweet. It's filled in when exporting rather
+	// than stored.
+	TweetURL string `json:"tweet_url,omitempty"`
+
+	// RawID is the ID line as tweeted, before normalizeID. It isn't
+	// stored, since TweetText has it.
+	RawID string `json:"-"`
+
+	// IsTest is set for drills and test messages, which reports leave out
+	// unless asked.
+	IsTest bool `json:"is_test,omitempty"`
+
+	// TypeInferred is set when Type was guessed from the apparatus because
+	// the tweet had none.
+	TypeInferred bool `json:"type_inferred,omitempty"`
+
+	// TweetLang and TweetSource are the tweet's language and the client
+	// that posted it, as the source gave them, or empty if it didn't. A
+	// change of client often comes with a change of format.
+	TweetLang   string `json:"tweet_lang,omitempty"`
+	TweetSource string `json:"tweet_source,omitempty"`
+
+	// Tags are from the -tags rules matching the tweet, stored in
+	// incident_tags.
+	Tags []string `json:"tags,omitempty"`
+
+	// ParserVersion is the parserVersion that produced the incident, or 0
+	// if that isn't known.
+	ParserVersion int `json:"parser_version,omitempty"`
+}
+
+func parse(s string) (Incident, error) {
+	s = html.UnescapeString(s)
+	// Some archives and non-Twitter sources use Windows line endings.
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	lines := strings.Split(s, "\n")
+	layout := parseOpts.lines()
+	if len(lines) != layout.count() {
+		return Incident{}, fmt.Errorf("bad tweet with %v lines", len(lines))
+	}
+	loc := lines[layout.location]
+	var comm string
+	if parseOpts.noCommunitySplit {
+		loc = strings.TrimSpace(loc)
+	} else {
+		loc, comm = splitLocation(loc)
+	}
+
+	in := Incident{
+		ID:        normalizeID(lines[layout.id]),
+		RawID:     lines[layout.id],
+		Location:  loc,
+		Community: normalizeCommunity(comm),
+		Type:      strings.Join(strings.Fields(lines[layout.typ]), " "),
+
+		ParserVersion: parserVersion,
+	}
+	if comms := splitCommunities(comm); comms != nil {
+		in.Community = comms[0]
+		in.Communities = comms
+	}
+	if in.ID == "" {
+		return Incident{}, errors.New("bad tweet with no incident ID")
+	}
+	if in.Location == "" {
+		return Incident{}, errors.New("bad tweet with no location")
+	}
+	if !parseOpts.keepTypeHashtags {
+		in.Type = stripHashtags(in.Type)
+	}
+	for i, l := range lines {
+		// The location can have a marker word in a street name.
+		if i != layout.location && testMarkerRe.MatchString(l) {
+			in.IsTest = true
+		}
+	}
+	in.Type, in.DispatchClock = splitDispatchClock(in.Type)
+	in.Type, in.Disposition = splitDisposition(in.Type)
+
+	apparatuses := make(map[string]struct{})
+	stations := make(map[string]struct{})
+	apparatusLine := lines[layout.apparatus]
+	if in.DispatchClock == "" {
+		apparatusLine, in.DispatchClock = splitDispatchClock(apparatusLine)
+	}
+	for _, f := range strings.Fields(stripApparatusLabel(apparatusLine)) {
+		if parseOpts.isStation(f) {
+			stations[f] = struct{}{}
+			continue
+		}
+		apparatuses[f] = struct{}{}
+	}
+
+	// A line of only stations leaves no apparatus. That's still a valid
+	// incident, dispatched without the units being known, and is stored
+	// with an apparatus_count of 0, which -stats counts.
+	in.Apparatuses = maps.Keys(apparatuses)
+	sort.Strings(in.Apparatuses)
+
+	in.Stations = maps.Keys(stations)
+	sort.Strings(in.Stations)
+
+	if strings.TrimSpace(in.Type) == "" {
+		t := inferType(in.Apparatuses)
+		if t == "" {
+			return Incident{}, errors.New("bad tweet with no type")
+		}
+		in.Type = t
+		in.TypeInferred = true
+	}
+
+	return in, nil
+}
+
+var (
+	engineRe = regexp.MustCompile(`^E\d+$`)
+	ladderRe = regexp.MustCompile(`^L\d+$`)
+)
+
+// inferType guesses a coarse incident type from the responding apparatus,
+// for tweets with no type line. It's deliberately conservative: a ladder
+// plus at least two engines is treated as a fire, and anything else is left
+// unknown and parse rejects the tweet.
+func inferType(apparatuses []string) string {
+	var engines, ladders int
+	for _, a := range apparatuses {
+		switch {
+		case engineRe.MatchString(a):
+			engines++
+		case ladderRe.MatchString(a):
+			ladders++
+		}
+	}
+	if ladders > 0 && engines >= 2 {
+		return "FIRE"
+	}
+	return ""
+}
+
+// stripHashtags removes any #hashtag words from the end of s, so a type
+// like "STRUCTURE FIRE #Halifax" groups with plain "STRUCTURE FIRE".
+func stripHashtags(s string) string {
+	fields := strings.Fields(s)
+	n := len(fields)
+	for n > 0 && strings.HasPrefix(fields[n-1], "#") {
+		n--
+	}
+	if n == len(fields) {
+		return s
+	}
+	return strings.Join(fields[:n], " ")
+}
+
+// testMarkerRe matches the words marking a tweet as a drill or test rather
+// than a real incident.
+var testMarkerRe = regexp.MustCompile(`(?i)\b(?:TEST|DRILL|TRAINING)\b`)
+
+// dispatchClockRe matches a dispatch time in a line, as in "@ 23:58" or
+// "Dispatched 23:58", capturing the hours and minutes. The @ or Dispatched
+// is required, since a bare time like the one in "MVC 2:30 AM" may not be
+// when the incident was dispatched.
+var dispatchClockRe = regexp.MustCompile(`(?i)\s*(?:@|\bdisp(?:atched)?:?)\s*\b([01]?\d|2[0-3]):([0-5]\d)\b`)
+
+// splitDispatchClock removes the first dispatch time from line, returning
+// the rest of the line and the time as "HH:MM", or "" if there's none.
+func splitDispatchClock(line string) (string, string) {
+	m := dispatchClockRe.FindStringSubmatchIndex(line)
+	if m == nil {
+		return line, ""
+	}
+	h, _ := strconv.Atoi(line[m[2]:m[3]])
+	clock := fmt.Sprintf("%02d:%s", h, line[m[4]:m[5]])
+	return strings.TrimSpace(strings.TrimRight(line[:m[0]], " ") + " " + strings.TrimLeft(line[m[1]:], " ")), clock
+}
+
+// setDispatchedAt sets in.DispatchedAt from its DispatchClock and
+// CreatedAt, if it has a dispatch time.
+func (in *Incident) setDispatchedAt() {
+	if in.DispatchClock == "" {
+		return
+	}
+	if t, ok := dispatchedAt(in.DispatchClock, in.CreatedAt); ok {
+		in.DispatchedAt = &t
+	}
+}
+
+// dispatchedAt combines a dispatch time of day from a tweet, in the feed's
+// time zone, with the date the tweet was posted. An incident is dispatched
+// before it's tweeted, so a time later than the posting, like 23:58 for a
+// tweet posted at 00:03, is taken to be from the day before.
+func dispatchedAt(clock string, posted time.Time) (time.Time, bool) {
+	c, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, false
+	}
+	p := posted.In(feedLoc)
+	t := time.Date(p.Year(), p.Month(), p.Day(), c.Hour(), c.Minute(), 0, 0, feedLoc)
+	if t.After(posted) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t.UTC(), true
+}
+
+// dispositions are the outcomes recognized after an incident type.
+var dispositions = []string{
+	"TRANSPORTED",
+	"NO PATIENT",
+	"NO TRANSPORT",
+	"CONTROLLED",
+	"EXTINGUISHED",
+	"UNDER CONTROL",
+	"CANCELLED",
+	"UNFOUNDED",
+	"FALSE ALARM",
+}
+
+// dispositionRe matches a disposition separated from the type before it by
+// a dash, comma or parentheses, the ways feeds have been seen to add one.
+var dispositionRe = regexp.MustCompile(`^(.*\S)\s*(?:\s-\s*|,\s*|\()([^,()-]+?)\)?\s*$`)
+
+// splitDisposition splits a recognized disposition off the end of an
+// incident type, returning the type without it and the disposition. Types
+// without one are returned unchanged. Only the separated forms count, so a
+// type that merely ends in one of the words is left alone.
+func splitDisposition(typ string) (string, string) {
+	m := dispositionRe.FindStringSubmatch(typ)
+	if m == nil {
+		return typ, ""
+	}
+	d := strings.ToUpper(strings.TrimSpace(m[2]))
+	for _, known := range dispositions {
+		if d == known {
+			return m[1], d
+		}
+	}
+	return typ, ""
+}
+
+// apparatusLabels are labels some tweets put before the apparatus list, as
+// in "Units: E2 L4 STN5".
+var apparatusLabels = []string{"Units:", "Unit:", "Apparatus:"}
+
+// stripApparatusLabel removes a recognized label from the start of an
+// apparatus line so it isn't stored as a unit.
+func stripApparatusLabel(s string) string {
+	return stripLabel(s, apparatusLabels)
+}
+
+// idLabels are labels some tweets put before the incident ID, as in
+// "Ref: 22-001".
+var idLabels = []string{"Ref:", "Ref #", "Ref#", "Ref ", "Incident:", "Inc:"}
+
+// stripLabel removes the first of labels found at the start of s, ignoring
+// case and leading space. s is returned unchanged if none is.
+func stripLabel(s string, labels []string) string {
+	t := strings.TrimSpace(s)
+	for _, l := range labels {
+		if len(t) >= len(l) && strings.EqualFold(t[:len(l)], l) {
+			return t[len(l):]
+		}
+	}
+	return s
+}
+
+// streetSuffixes are the last words of street names, upper-cased.
+var streetSuffixes = map[string]bool{
+	"ST": true, "RD": true, "AVE": true, "DR": true, "CRT": true, "CT": true,
+	"LANE": true, "LN": true, "HWY": true, "BLVD": true, "CRES": true,
+	"TERR": true, "PL": true, "WAY": true, "ROAD": true, "STREET": true,
+}
+
+// looksLikeStreet reports whether s looks like part of a street address
+// rather than a community name: it has a digit or ends in a street suffix.
+func looksLikeStreet(s string) bool {
+	if strings.ContainsAny(s, "0123456789") {
+		return true
+	}
+	fields := strings.Fields(s)
+	return len(fields) > 0 && streetSuffixes[strings.ToUpper(strings.TrimSuffix(fields[len(fields)-1], "."))]
+}
+
+// splitLocation splits a location line into the location and community,
+// which HRFE separates with two or more spaces or, less often, a comma.
+func splitLocation(line string) (loc, comm string) {
+	// Runs of whitespace become one or two spaces, as in stored normalized
+	// text, so either splits the same way.
+	loc = normalizeTweetText(line)
+	locParts := strings.Split(loc, "  ")
+	switch len(locParts) {
+	case 1:
+		loc, comm = splitCommaCommunity(loc)
+	case 2:
+		loc = strings.TrimSpace(locParts[0])
+		comm = strings.TrimSpace(locParts[1])
+		// A stray double space inside the street would otherwise make
+		// the rest of it the community.
+		if !knownCommunity(comm) && looksLikeStreet(comm) {
+			loc, comm = loc+" "+comm, ""
+		}
+	}
+	return loc, comm
+}
+
+// splitCommaCommunity splits a location like "123 Main St, Dartmouth" on its
+// last comma. It only does so when the part after the comma looks like a
+// community name (a few words, no digits) so business names such as
+// "Smith, Jones & Co 12 Main St" are left alone.
+func splitCommaCommunity(loc string) (string, string) {
+	i := strings.LastIndex(loc, ",")
+	if i < 0 {
+		return loc, ""
+	}
+	rest, comm := strings.TrimSpace(loc[:i]), strings.TrimSpace(loc[i+1:])
+	if rest == "" || comm == "" || strings.ContainsAny(comm, "0123456789&") || len(strings.Fields(comm)) > 3 {
+		return loc, ""
+	}
+	return rest, comm
+}
+
+// storeNormalizedText is whether incidents are stored with a normalized
+// copy of their tweet text, set from flags.
+var storeNormalizedText bool
+
+// whitespaceRe matches runs of whitespace within a line.
+var whitespaceRe = regexp.MustCompile(`[^\S\n]+`)
+
+// normalizeTweetText trims each line of s and collapses runs of whitespace
+// within it: a single space stays one space and anything longer becomes
+// two, since two spaces separate the location from the community. The
+// result parses the same as s.
+func normalizeTweetText(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = whitespaceRe.ReplaceAllStringFunc(strings.TrimSpace(l), func(ws string) string {
+			if len(ws) == 1 {
+				return " "
+			}
+			return "  "
+		})
+	}
+	return strings.Join(lines, "\n")
+}
+
+// normalizeID cleans up an incident ID line so the same incident compares
+// equal across tweets, whether or not it has a label like "Ref:" or stray
+// spacing.
+func normalizeID(s string) string {
+	return strings.Join(strings.Fields(stripLabel(s, idLabels)), " ")
+}
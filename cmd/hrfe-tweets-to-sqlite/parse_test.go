@@ -0,0 +1,393 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCRLF(t *testing.T) {
+	for _, text := range []string{
+		"22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1 L4 STN2",
+		"22-2\n2 ELM ST, HALIFAX\nMEDICAL - Transported @ 23:58\nUnits: E3",
+	} {
+		want, err := parse(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parse(strings.ReplaceAll(text, "\n", "\r\n"))
+		if err != nil {
+			t.Fatalf("%q with CRLF: %v", text, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q with CRLF parsed as\n%+v\nwant\n%+v", text, got, want)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, text := range []string{
+		"22-12345\n123 MAIN ST  DARTMOUTH\nFIRE ALARM - COMMERCIAL\nE3 L4 STN3",
+		"22-12346\nPORTLAND ST &amp; PLEASANT ST  DARTMOUTH\nMVC - WITH INJURIES\nE12 R2 STN12",
+		"22-12347\n6 BEDFORD HWY, BEDFORD\nMEDICAL - Transported @ 23:58\nUnits: E9",
+		"22-12348\n10 LAKEVIEW RD  LOWER SACKVILLE/FALL RIVER\nSTRUCTURE FIRE\nE20 E21 T14 STN20 STN21",
+		"22-12349\n1 MAIN ST  DARTMOUTH\r\nDRILL\r\nSTN5",
+		"22-12350 \n1 MAIN ST\t\tDARTMOUTH\nSTRUCTURE   FIRE \nE1 L4",
+		"22-12351\n 1 MAIN ST\t2\nFIRE\nE1",
+		"22-1\n\n\n",
+		"not an incident",
+		"",
+	} {
+		f.Add(text)
+	}
+	for _, tw := range seedTweets {
+		f.Add(tw.Text)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		in, err := parse(text)
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(in.ID) == "" || strings.TrimSpace(in.Location) == "" || strings.TrimSpace(in.Type) == "" {
+			t.Fatalf("%q parsed with a missing ID, location or type: %+v", text, in)
+		}
+		normalized, err := parse(normalizeTweetText(text))
+		if err != nil {
+			t.Fatalf("%q parsed but normalized it doesn't: %v", text, err)
+		}
+		// RawID is the line as tweeted, which normalizing changes.
+		in.RawID, normalized.RawID = "", ""
+		if !reflect.DeepEqual(normalized, in) {
+			t.Fatalf("%q parsed as\n%+v\nbut normalized as\n%+v", text, in, normalized)
+		}
+	})
+}
+
+func TestInferTypeKeepsExplicit(t *testing.T) {
+	for _, tt := range []struct {
+		text         string
+		wantType     string
+		wantInferred bool
+	}{
+		{"22-1\n1 MAIN ST  DARTMOUTH\nMEDICAL\nE1 E2 L4", "MEDICAL", false},
+		{"22-2\n1 MAIN ST  DARTMOUTH\nFIRE ALARM\nE1 E2 L4", "FIRE ALARM", false},
+		{"22-3\n1 MAIN ST  DARTMOUTH\n \nE1 E2 L4", "FIRE", true},
+	} {
+		in, err := parse(tt.text)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.text, err)
+		}
+		if strings.TrimSpace(in.Type) != tt.wantType || in.TypeInferred != tt.wantInferred {
+			t.Errorf("%q: type %q, inferred %v, want %q, %v", tt.text, in.Type, in.TypeInferred, tt.wantType, tt.wantInferred)
+		}
+	}
+}
+
+func TestParseMissingFields(t *testing.T) {
+	for _, text := range []string{
+		"22-1\n\n\n",
+		" \n1 MAIN ST  DARTMOUTH\nFIRE\nE1",
+		"Ref:\n1 MAIN ST  DARTMOUTH\nFIRE\nE1",
+		"22-1\n \t\nFIRE\nE1",
+		// Nothing to infer a type from.
+		"22-1\n1 MAIN ST  DARTMOUTH\n\nE1 L4",
+		"22-1\n1 MAIN ST  DARTMOUTH\n@ 23:58\nE1",
+	} {
+		if in, err := parse(text); err == nil {
+			t.Errorf("%q parsed as %+v, want an error", text, in)
+		}
+	}
+}
+
+// setParseOpts sets parseOpts to o until the test ends.
+func setParseOpts(t *testing.T, o parseOptions) {
+	t.Helper()
+	old := parseOpts
+	parseOpts = o
+	t.Cleanup(func() { parseOpts = old })
+}
+
+func TestParseCustomLayout(t *testing.T) {
+	l, err := parseLayout("0, 2, 1, 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setParseOpts(t, parseOptions{layout: &l})
+
+	in, err := parse("22-1\nFIRE\n1 MAIN ST  DARTMOUTH\nsome notes\nE1 L4 STN2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.ID != "22-1" || in.Type != "FIRE" || in.Location != "1 MAIN ST" || in.Community != "DARTMOUTH" ||
+		!reflect.DeepEqual(in.Apparatuses, []string{"E1", "L4"}) || !reflect.DeepEqual(in.Stations, []string{"STN2"}) {
+		t.Errorf("got %+v", in)
+	}
+	// HRFE's four lines are now too few.
+	if _, err := parse("22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1"); err == nil {
+		t.Error("parsed a four line tweet, want an error")
+	}
+
+	for _, bad := range []string{"0,1,2", "0,1,2,x", "0,1,1,3", "0,1,2,-1"} {
+		if _, err := parseLayout(bad); err == nil {
+			t.Errorf("parseLayout(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestDispatchedAtMidnight(t *testing.T) {
+	// 00:03 in Halifax on March 5th.
+	posted := time.Date(2022, 3, 5, 4, 3, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		clock string
+		want  time.Time
+	}{
+		// Before midnight, so the day before.
+		{"23:58", time.Date(2022, 3, 5, 3, 58, 0, 0, time.UTC)},
+		{"00:01", time.Date(2022, 3, 5, 4, 1, 0, 0, time.UTC)},
+		{"00:03", posted},
+	} {
+		got, ok := dispatchedAt(tt.clock, posted)
+		if !ok || !got.Equal(tt.want) {
+			t.Errorf("dispatchedAt(%q) = %v, %v, want %v", tt.clock, got, ok, tt.want)
+		}
+	}
+	if _, ok := dispatchedAt("25:00", posted); ok {
+		t.Error("dispatchedAt(25:00) succeeded, want it to fail")
+	}
+
+	in, err := parse("22-1\n1 MAIN ST  DARTMOUTH\nFIRE @ 23:58\nE1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.CreatedAt = posted
+	in.setDispatchedAt()
+	if in.DispatchedAt == nil || !in.DispatchedAt.Equal(time.Date(2022, 3, 5, 3, 58, 0, 0, time.UTC)) {
+		t.Errorf("DispatchedAt = %v, want 23:58 the day before", in.DispatchedAt)
+	}
+
+	for _, tt := range []struct {
+		text, clock, typ string
+	}{
+		{"22-1\n1 MAIN ST  DARTMOUTH\nFIRE @23:58\nE1", "23:58", "FIRE"},
+		{"22-1\n1 MAIN ST  DARTMOUTH\nFIRE Dispatched: 0:05\nE1", "00:05", "FIRE"},
+		{"22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1 L4 disp 23:58", "23:58", "FIRE"},
+		// A bare time isn't taken for the dispatch time.
+		{"22-1\n1 MAIN ST  DARTMOUTH\nMVC 2:30 AM\nE1", "", "MVC 2:30 AM"},
+		{"22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1 L4 10:15", "", "FIRE"},
+		{"22-1\n1 MAIN ST  DARTMOUTH\nFIRE @ 24:00\nE1", "", "FIRE @ 24:00"},
+	} {
+		in, err := parse(tt.text)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.text, err)
+		}
+		if in.DispatchClock != tt.clock || in.Type != tt.typ {
+			t.Errorf("%q: clock %q, type %q, want %q, %q", tt.text, in.DispatchClock, in.Type, tt.clock, tt.typ)
+		}
+	}
+}
+
+func TestParseNoCommunitySplit(t *testing.T) {
+	locs := []string{
+		"1 MAIN ST   DARTMOUTH/COLE HARBOUR",
+		"2 ELM ST, HALIFAX",
+		"3 OAK ST  BEDFORD",
+	}
+	text := func(loc string) string { return "22-1\n " + loc + " \nFIRE\nE1" }
+
+	for _, loc := range locs {
+		in, err := parse(text(loc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if in.Community == "" {
+			t.Fatalf("%q: split into no community by default", loc)
+		}
+	}
+
+	setParseOpts(t, parseOptions{noCommunitySplit: true})
+	for _, loc := range locs {
+		in, err := parse(text(loc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if in.Location != loc || in.Community != "" || in.Communities != nil {
+			t.Errorf("%q: got location %q, community %q, communities %q, want the whole line and none", loc, in.Location, in.Community, in.Communities)
+		}
+	}
+}
+
+func TestSplitLocation(t *testing.T) {
+	for _, tt := range []struct {
+		line, loc, comm string
+	}{
+		{"123 MAIN ST  DARTMOUTH", "123 MAIN ST", "DARTMOUTH"},
+		{"123 MAIN ST     DARTMOUTH", "123 MAIN ST", "DARTMOUTH"},
+		{"123 Main St, Dartmouth", "123 Main St", "Dartmouth"},
+		{"123 Main St, Cole Harbour", "123 Main St", "Cole Harbour"},
+		{"PORTLAND ST & PLEASANT ST, DARTMOUTH", "PORTLAND ST & PLEASANT ST", "DARTMOUTH"},
+		// Business names with commas aren't split.
+		{"Smith, Jones & Co 12 Main St", "Smith, Jones & Co 12 Main St", ""},
+		{"Halifax Shopping Centre, 7001 Mumford Rd", "Halifax Shopping Centre, 7001 Mumford Rd", ""},
+		{"123 MAIN ST,", "123 MAIN ST,", ""},
+		{"123 MAIN ST", "123 MAIN ST", ""},
+	} {
+		loc, comm := splitLocation(tt.line)
+		if loc != tt.loc || comm != tt.comm {
+			t.Errorf("splitLocation(%q) = %q, %q, want %q, %q", tt.line, loc, comm, tt.loc, tt.comm)
+		}
+	}
+}
+
+func TestStripHashtags(t *testing.T) {
+	for s, want := range map[string]string{
+		"STRUCTURE FIRE #Halifax":       "STRUCTURE FIRE",
+		"STRUCTURE FIRE #Halifax #HRFE": "STRUCTURE FIRE",
+		"STRUCTURE FIRE":                "STRUCTURE FIRE",
+		"FIRE #2 ALARM":                 "FIRE #2 ALARM",
+		"#HRFE":                         "",
+	} {
+		if got := stripHashtags(s); got != want {
+			t.Errorf("stripHashtags(%q) = %q, want %q", s, got, want)
+		}
+	}
+
+	const text = "22-1\n1 MAIN ST  DARTMOUTH\nSTRUCTURE FIRE #Halifax\nE1 L4"
+	for _, tt := range []struct {
+		keep bool
+		want string
+	}{
+		{false, "STRUCTURE FIRE"},
+		{true, "STRUCTURE FIRE #Halifax"},
+	} {
+		setParseOpts(t, parseOptions{keepTypeHashtags: tt.keep})
+		in, err := parse(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if in.Type != tt.want {
+			t.Errorf("keeping hashtags %v: type %q, want %q", tt.keep, in.Type, tt.want)
+		}
+	}
+}
+
+func TestStripLabel(t *testing.T) {
+	for s, want := range map[string]string{
+		"Units: E1 L4":     " E1 L4",
+		"  units:E1 L4":    "E1 L4",
+		"Unit: E1":         " E1",
+		"APPARATUS: E1 L4": " E1 L4",
+		"E1 L4":            "E1 L4",
+		"E1 Units: L4":     "E1 Units: L4",
+	} {
+		if got := stripApparatusLabel(s); got != want {
+			t.Errorf("stripApparatusLabel(%q) = %q, want %q", s, got, want)
+		}
+	}
+
+	// Labeled or not, the same units and stations.
+	labeled, err := parse("22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nUnits: E1 L4 STN2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bare, err := parse("22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1 L4 STN2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(labeled.Apparatuses, bare.Apparatuses) || !reflect.DeepEqual(labeled.Stations, bare.Stations) {
+		t.Errorf("labeled %q, %q, unlabeled %q, %q", labeled.Apparatuses, labeled.Stations, bare.Apparatuses, bare.Stations)
+	}
+	if want := []string{"E1", "L4"}; !reflect.DeepEqual(labeled.Apparatuses, want) {
+		t.Errorf("apparatuses %q, want %q", labeled.Apparatuses, want)
+	}
+}
+
+func TestNormalizeID(t *testing.T) {
+	for s, want := range map[string]string{
+		"22-001":           "22-001",
+		"Ref: 22-001":      "22-001",
+		"REF #22-001":      "22-001",
+		"Ref#22-001":       "22-001",
+		"Ref 22-001":       "22-001",
+		"Incident: 22-001": "22-001",
+		"inc:22-001 ":      "22-001",
+		"  22-001":         "22-001",
+	} {
+		if got := normalizeID(s); got != want {
+			t.Errorf("normalizeID(%q) = %q, want %q", s, got, want)
+		}
+	}
+
+	// Labeled and bare tweets of the same incident group together.
+	labeled, err := parse("Ref: 22-001\n1 MAIN ST  DARTMOUTH\nFIRE\nE1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bare, err := parse("22-001\n1 MAIN ST  DARTMOUTH\nFIRE\nE1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labeled.ID != bare.ID || labeled.RawID != "Ref: 22-001" {
+		t.Errorf("labeled ID %q (raw %q), bare %q", labeled.ID, labeled.RawID, bare.ID)
+	}
+}
+
+func TestStationPrefixes(t *testing.T) {
+	const text = "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1 L4 STA5 STN6"
+	for _, tt := range []struct {
+		prefixes              string
+		apparatuses, stations []string
+	}{
+		{"", []string{"E1", "L4", "STA5"}, []string{"STN6"}},
+		{"STA", []string{"E1", "L4", "STN6"}, []string{"STA5"}},
+		{"STA, STN", []string{"E1", "L4"}, []string{"STA5", "STN6"}},
+	} {
+		var o parseOptions
+		if tt.prefixes != "" {
+			p, err := parseStationPrefixes(tt.prefixes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			o.stationPrefixes = p
+		}
+		setParseOpts(t, o)
+		in, err := parse(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(in.Apparatuses, tt.apparatuses) || !reflect.DeepEqual(in.Stations, tt.stations) {
+			t.Errorf("prefixes %q: apparatuses %q, stations %q, want %q, %q", tt.prefixes, in.Apparatuses, in.Stations, tt.apparatuses, tt.stations)
+		}
+	}
+
+	for _, s := range []string{"STA,", ",STA", " "} {
+		if _, err := parseStationPrefixes(s); err == nil {
+			t.Errorf("parseStationPrefixes(%q) gave no error", s)
+		}
+	}
+}
+
+func TestSplitDisposition(t *testing.T) {
+	for _, tt := range []struct {
+		typ, wantType, wantDisposition string
+	}{
+		{"MEDICAL - Transported", "MEDICAL", "TRANSPORTED"},
+		{"MEDICAL -Transported", "MEDICAL", "TRANSPORTED"},
+		{"FIRE, extinguished", "FIRE", "EXTINGUISHED"},
+		{"FIRE ALARM (False Alarm)", "FIRE ALARM", "FALSE ALARM"},
+		{"MVC - NO TRANSPORT", "MVC", "NO TRANSPORT"},
+		// Without one.
+		{"MEDICAL", "MEDICAL", ""},
+		{"FIRE ALARM - COMMERCIAL", "FIRE ALARM - COMMERCIAL", ""},
+		{"MVC - WITH INJURIES", "MVC - WITH INJURIES", ""},
+		// Only the separated forms count.
+		{"BRUSH FIRE CONTROLLED", "BRUSH FIRE CONTROLLED", ""},
+		{"TRANSPORTED", "TRANSPORTED", ""},
+		{"", "", ""},
+	} {
+		typ, disposition := splitDisposition(tt.typ)
+		if typ != tt.wantType || disposition != tt.wantDisposition {
+			t.Errorf("splitDisposition(%q) = %q, %q, want %q, %q", tt.typ, typ, disposition, tt.wantType, tt.wantDisposition)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// progress reports how far a backfill has got. On a terminal it redraws a
+// bar in place; otherwise it logs a line every progressLogInterval.
+type progress struct {
+	w      io.Writer
+	tty    bool
+	drawn  bool      // a bar is on screen and needs finishing with a newline
+	logged time.Time // when the last non-terminal line was logged
+}
+
+// progressLogInterval is how often progress is logged when not on a terminal.
+const progressLogInterval = 30 * time.Second
+
+// progressBarWidth is the number of cells in the drawn bar.
+const progressBarWidth = 30
+
+// newProgress reports progress to f, drawing a bar only if f is a terminal.
+func newProgress(f *os.File) *progress {
+	return &progress{w: f, tty: isTerminal(f)}
+}
+
+// update reports that seen of total tweets are stored and the backfill has
+// reached back to cursor. total is the account's tweet count, so the
+// fraction is an estimate: deleted tweets and the API's limit on how far
+// back a timeline goes both mean it may never reach 100%.
+func (p *progress) update(seen, total int, cursor time.Time) {
+	frac := 0.0
+	if total > 0 {
+		frac = float64(seen) / float64(total)
+	}
+	if frac > 1 {
+		frac = 1
+	}
+	status := fmt.Sprintf("%3.0f%% %d/%d tweets, back to %s", frac*100, seen, total, cursor.Format("2006-01-02"))
+
+	if !p.tty {
+		if now := time.Now(); now.Sub(p.logged) >= progressLogInterval {
+			p.logged = now
+			log.Printf("backfill: %s", status)
+		}
+		return
+	}
+	filled := int(frac * progressBarWidth)
+	fmt.Fprintf(p.w, "\r[%s%s] %s", strings.Repeat("#", filled), strings.Repeat(" ", progressBarWidth-filled), status)
+	p.drawn = true
+}
+
+// done finishes the bar so later output starts on its own line.
+func (p *progress) done() {
+	if p.drawn {
+		fmt.Fprintln(p.w)
+		p.drawn = false
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+)
+
+// repairTimestamps rewrites any incidents whose created_at isn't the UTC
+// time the tweet was posted, as could happen with older versions storing
+// local times. The posting time comes from tweet_created_at or, failing that,
+// the tweet ID. Unless apply is set nothing is changed. It returns how many
+// rows need or needed repair, and is safe to run repeatedly.
+func repairTimestamps(w io.Writer, db *sql.DB, apply bool) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("select tweet_id, created_at, tweet_created_at from incidents order by tweet_id")
+	if err != nil {
+		return 0, err
+	}
+	type fix struct {
+		tweetID int64
+		at      time.Time
+	}
+	var fixes []fix
+	for rows.Next() {
+		var (
+			tweetID                   int64
+			createdAt, tweetCreatedAt any
+		)
+		if err := rows.Scan(&tweetID, &createdAt, &tweetCreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		want, ok := tweetCreatedAt.(time.Time)
+		if !ok {
+			want = tweetIDTime(tweetID)
+		}
+		want = want.UTC()
+		if have, ok := createdAt.(time.Time); ok && have.Equal(want) && zoneOffset(have) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "tweet id=%v: created_at %v -> %v\n", tweetID, createdAt, want)
+		fixes = append(fixes, fix{tweetID, want})
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	if !apply {
+		return len(fixes), nil
+	}
+	for _, f := range fixes {
+		if _, err := tx.Exec("update incidents set created_at = ? where tweet_id = ?", f.at, f.tweetID); err != nil {
+			return 0, err
+		}
+	}
+	return len(fixes), tx.Commit()
+}
+
+func zoneOffset(t time.Time) int {
+	_, off := t.Zone()
+	return off
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reparse parses the stored tweet text of every incident last parsed by an
+// older parser again, updating its parsed fields and parser_version. The
+// normalized text is used where there is one, and the registered enrichers
+// run on each incident as when it was first stored. Rows whose text no
+// longer parses, or that an enricher rejects, are reported to w and left
+// alone. It returns how many rows were updated. With all set every row is
+// parsed again, as for applying changed -tags or -communities files. Rows
+// are worked through batch at a time in tweet ID order, committing after
+// each, or all at once if batch is 0. Only the join tables in tables are
+// rewritten.
+func reparse(w io.Writer, db *sql.DB, all bool, batch int, tables reparseTables) (int, error) {
+	version := parserVersion
+	if all {
+		version = math.MaxInt
+	}
+
+	var (
+		updated int
+		after   int64
+	)
+	for {
+		n, last, more, err := reparseBatch(w, db, version, after, batch, tables)
+		updated += n
+		if err != nil || !more {
+			return updated, err
+		}
+		after = last
+	}
+}
+
+// reparseTables says which tables derived from incidents' text reparse
+// rewrites. Each depends on rules that may not be loaded, and rewriting it
+// without them would throw away what they found before.
+type reparseTables struct {
+	tags        bool // incident_tags, from -tags
+	communities bool // incident_communities, from -communities or the built-in aliases
+}
+
+// reparseBatch reparses up to batch rows older than version with tweet IDs
+// after the given one, returning how many were updated, the last tweet ID
+// looked at and whether there may be more.
+func reparseBatch(w io.Writer, db *sql.DB, version int, after int64, batch int, tables reparseTables) (int, int64, bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer tx.Rollback()
+
+	q := "select tweet_id, coalesce(tweet_text_normalized, tweet_text), created_at, tweet_lang, tweet_source from incidents where coalesce(parser_version, 0) < ? and tweet_id > ? order by tweet_id"
+	args := []any{version, after}
+	if batch > 0 {
+		q += " limit ?"
+		args = append(args, batch)
+	}
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	var (
+		ins     []Incident
+		scanned int
+		last    int64
+	)
+	for rows.Next() {
+		var (
+			tweetID      int64
+			text         string
+			createdAt    time.Time
+			lang, source string
+		)
+		if err := rows.Scan(&tweetID, &text, &createdAt, &lang, &source); err != nil {
+			rows.Close()
+			return 0, 0, false, err
+		}
+		scanned++
+		last = tweetID
+		in, err := parse(text)
+		if err != nil {
+			fmt.Fprintf(w, "tweet id=%v: %v\n", tweetID, err)
+			continue
+		}
+		in.TweetID = tweetID
+		in.TweetText = text
+		in.CreatedAt = createdAt
+		in.setDispatchedAt()
+		in.TweetLang = lang
+		in.TweetSource = source
+		if err := enrich(&in); err != nil {
+			fmt.Fprintf(w, "tweet id=%v: enrich: %v\n", tweetID, err)
+			continue
+		}
+		ins = append(ins, in)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, 0, false, err
+	}
+
+	for _, in := range ins {
+		if _, err := tx.Exec(
+			"update incidents set id = ?, location = ?, community = ?, type = ?, apparatuses = ?, station = ?, apparatus_count = ?, station_count = ?, type_inferred = ?, disposition = ?, dispatched_at = ?, is_test = ?, parser_version = ? where tweet_id = ?",
+			in.ID, in.Location, in.Community, in.Type, strings.Join(in.Apparatuses, " "), strings.Join(in.Stations, " "), len(in.Apparatuses), len(in.Stations), in.TypeInferred, in.Disposition, in.DispatchedAt, in.IsTest, parserVersion, in.TweetID,
+		); err != nil {
+			return 0, 0, false, fmt.Errorf("tweet id=%v: %w", in.TweetID, err)
+		}
+		if tables.communities {
+			if _, err := tx.Exec("delete from incident_communities where tweet_id = ?", in.TweetID); err != nil {
+				return 0, 0, false, err
+			}
+			for _, c := range in.Communities {
+				if _, err := tx.Exec("insert into incident_communities values (?, ?)", in.TweetID, c); err != nil {
+					return 0, 0, false, err
+				}
+			}
+		}
+		if tables.tags {
+			if _, err := tx.Exec("delete from incident_tags where tweet_id = ?", in.TweetID); err != nil {
+				return 0, 0, false, err
+			}
+			for _, t := range tagsFor(in.TweetText) {
+				if _, err := tx.Exec("insert into incident_tags values (?, ?)", in.TweetID, t); err != nil {
+					return 0, 0, false, err
+				}
+			}
+		}
+	}
+	return len(ins), last, batch > 0 && scanned == batch, tx.Commit()
+}
+
+// reprocessErrors retries the tweets in processing_errors, as after a parser
+// fix. Those that now parse are stored as if just fetched and their errors
+// cleared; the rest are left for next time. It returns how many were
+// recovered and how many still fail, and is safe to run repeatedly. Like
+// reparse, it commits every batch tweets, or once if batch is 0.
+func reprocessErrors(im *importer, batch int) (recovered, remaining int, err error) {
+	var after int64
+	for {
+		r, n, last, more, err := reprocessErrorsBatch(im, after, batch)
+		recovered += r
+		remaining += n
+		if err != nil || !more {
+			return recovered, remaining, err
+		}
+		after = last
+	}
+}
+
+// reprocessErrorsBatch retries up to batch failed tweets with IDs after the
+// given one, returning how many were recovered and still fail, the last
+// tweet ID looked at and whether there may be more.
+func reprocessErrorsBatch(im *importer, after int64, batch int) (recovered, remaining int, last int64, more bool, err error) {
+	tx, err := im.db.Begin()
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	defer tx.Rollback()
+
+	// A tweet may have failed more than once; its latest attempt has the
+	// text to use.
+	q := `select tweet_id, tweet_text, tweet_created_at from processing_errors
+		where rowid in (select max(rowid) from processing_errors group by tweet_id) and tweet_id > ? order by tweet_id`
+	args := []any{after}
+	if batch > 0 {
+		q += " limit ?"
+		args = append(args, batch)
+	}
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	var tweets []rawTweet
+	for rows.Next() {
+		var tw rawTweet
+		if err := rows.Scan(&tw.ID, &tw.Text, &tw.CreatedRaw); err != nil {
+			rows.Close()
+			return 0, 0, 0, false, err
+		}
+		last = tw.ID
+		// Recorded from the API or -stdin, so either's formats.
+		if t, ok := parseCreatedAt(tw.CreatedRaw); ok {
+			tw.Created = t
+		}
+		tweets = append(tweets, tw)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, 0, 0, false, err
+	}
+
+	for _, tw := range tweets {
+		// Check first, so tweets that still fail aren't recorded again.
+		if _, err := parse(tw.Text); err != nil {
+			remaining++
+			continue
+		}
+		if _, err := tw.createdAt(); err != nil {
+			remaining++
+			continue
+		}
+		// Clear the old errors first, since an enricher may fail again
+		// and record a new one.
+		if _, err := tx.Exec("delete from processing_errors where tweet_id = ?", tw.ID); err != nil {
+			return 0, 0, 0, false, err
+		}
+		if err := im.processTweet(tx, tw); err != nil {
+			return 0, 0, 0, false, fmt.Errorf("tweet id=%v: %w", tw.ID, err)
+		}
+		var failed bool
+		if err := tx.QueryRow("select exists (select 1 from processing_errors where tweet_id = ?)", tw.ID).Scan(&failed); err != nil {
+			return 0, 0, 0, false, err
+		}
+		if failed {
+			remaining++
+			continue
+		}
+		recovered++
+	}
+	return recovered, remaining, last, batch > 0 && len(tweets) == batch, tx.Commit()
+}
+
+// dumpUnparsed writes the tweets in processing_errors that currently fail to
+// parse to w, most recently recorded first, each with the error and its text
+// for studying. A limit above 0 stops after that many.
+func dumpUnparsed(w io.Writer, db *sql.DB, limit int) error {
+	q := `select tweet_id, message, tweet_text, recorded_at from processing_errors
+		where rowid in (select max(rowid) from processing_errors group by tweet_id) and class = ?
+		order by recorded_at desc, tweet_id desc`
+	args := []any{errorClassParse}
+	if limit > 0 {
+		q += " limit ?"
+		args = append(args, limit)
+	}
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			tweetID    int64
+			msg, text  string
+			recordedAt time.Time
+		)
+		if err := rows.Scan(&tweetID, &msg, &text, &recordedAt); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "tweet id=%v recorded %s: %s\n%s\n\n", tweetID, local(recordedAt).Format("2006-01-02 15:04 MST"), msg, text)
+	}
+	return rows.Err()
+}
+
+// benchmarkParse times parse over the stored text of every incident,
+// without changing anything, and writes the total, mean and the slowest n
+// tweets to w.
+func benchmarkParse(w io.Writer, db *sql.DB, n int) error {
+	type timing struct {
+		tweetID int64
+		took    time.Duration
+	}
+	var (
+		timings []timing
+		total   time.Duration
+		failed  int
+	)
+	rows, err := db.Query("select tweet_id, tweet_text from incidents")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			tweetID int64
+			text    string
+		)
+		if err := rows.Scan(&tweetID, &text); err != nil {
+			return err
+		}
+		start := time.Now()
+		_, err := parse(text)
+		took := time.Since(start)
+		if err != nil {
+			failed++
+		}
+		total += took
+		timings = append(timings, timing{tweetID, took})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(timings) == 0 {
+		fmt.Fprintln(w, "no incidents")
+		return nil
+	}
+
+	fmt.Fprintf(w, "parsed %d tweets (%d failed) in %v, mean %v\n", len(timings), failed, total, total/time.Duration(len(timings)))
+	sort.Slice(timings, func(i, j int) bool { return timings[i].took > timings[j].took })
+	if len(timings) > n {
+		timings = timings[:n]
+	}
+	for _, t := range timings {
+		fmt.Fprintf(w, "%v\ttweet id=%v\n", t.took, t.tweetID)
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReprocessErrors(t *testing.T) {
+	db := newTestDB(t)
+	// As if an older parser couldn't handle the first.
+	if err := recordError(db, testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1"), errorClassParse, errors.New("bad tweet")); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordError(db, testTweet(11, "not an incident"), errorClassParse, errors.New("bad tweet with 1 lines")); err != nil {
+		t.Fatal(err)
+	}
+
+	im := &importer{db: db, quiet: true}
+	recovered, remaining, err := reprocessErrors(im, 0)
+	if err != nil || recovered != 1 || remaining != 1 {
+		t.Fatalf("reprocessErrors = %d, %d, %v, want 1, 1", recovered, remaining, err)
+	}
+	var id int64
+	if err := db.QueryRow("select tweet_id from processing_errors").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if id != 11 {
+		t.Errorf("tweet %d left in processing_errors, want only 11", id)
+	}
+	if n := countRows(t, db, "incidents"); n != 1 {
+		t.Errorf("got %d incidents, want 1", n)
+	}
+
+	// Running again finds only the one that still fails.
+	recovered, remaining, err = reprocessErrors(im, 0)
+	if err != nil || recovered != 0 || remaining != 1 {
+		t.Errorf("second reprocessErrors = %d, %d, %v, want 0, 1", recovered, remaining, err)
+	}
+}
+
+// staleTestDB returns a database of incidents as stored by an older parser,
+// and of tweets it failed on.
+func staleTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	var tweets []rawTweet
+	for i := int64(1); i <= 7; i++ {
+		tweets = append(tweets, testTweet(i, fmt.Sprintf("22-%d\n%d MAIN ST  DARTMOUTH/COLE HARBOUR\nFIRE - Extinguished\nE%d STN%d", i, i, i, i)))
+	}
+	if err := im.process(tweets); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("update incidents set type = 'OLD', disposition = '', parser_version = 0 where tweet_id % 2 = 1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(10); i <= 14; i++ {
+		text := fmt.Sprintf("22-%d\n%d ELM ST  HALIFAX\nMEDICAL\nE3", i, i)
+		if i%2 == 0 {
+			text = "not an incident"
+		}
+		if err := recordError(db, testTweet(i, text), errorClassParse, errors.New("bad tweet")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// dumpTables returns the contents of the tables reparsing and reprocessing
+// change, for comparing databases.
+func dumpTables(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := exportJSON(&out, db, false, exportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"select tweet_id, coalesce(parser_version, 0) from incidents order by tweet_id",
+		"select tweet_id, community from incident_communities order by tweet_id, community",
+		"select tweet_id, class from processing_errors order by tweet_id",
+	} {
+		rows, err := db.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var id int64
+			var v any
+			if err := rows.Scan(&id, &v); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintln(&out, id, v)
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out.String()
+}
+
+func TestBatchesMatchSinglePass(t *testing.T) {
+	single := staleTestDB(t)
+	n, err := reparse(io.Discard, single, false, 0, reparseTables{communities: true})
+	if err != nil || n != 4 {
+		t.Fatalf("reparse in one pass = %v, %v, want 4", n, err)
+	}
+	recovered, remaining, err := reprocessErrors(&importer{db: single, quiet: true}, 0)
+	if err != nil || recovered != 2 || remaining != 3 {
+		t.Fatalf("reprocessErrors in one pass = %v, %v, %v, want 2, 3", recovered, remaining, err)
+	}
+	var old int
+	if err := single.QueryRow("select count(*) from incidents where type = 'OLD' or parser_version != ?", parserVersion).Scan(&old); err != nil || old != 0 {
+		t.Fatalf("%d incidents not reparsed, %v", old, err)
+	}
+	want := dumpTables(t, single)
+
+	for _, batch := range []int{1, 2, 3, 100} {
+		t.Run(fmt.Sprint(batch), func(t *testing.T) {
+			db := staleTestDB(t)
+			if n, err := reparse(io.Discard, db, false, batch, reparseTables{communities: true}); err != nil || n != 4 {
+				t.Fatalf("reparse = %v, %v, want 4", n, err)
+			}
+			recovered, remaining, err := reprocessErrors(&importer{db: db, quiet: true}, batch)
+			if err != nil || recovered != 2 || remaining != 3 {
+				t.Fatalf("reprocessErrors = %v, %v, %v, want 2, 3", recovered, remaining, err)
+			}
+			if got := dumpTables(t, db); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestReparseNormalizedText(t *testing.T) {
+	defer func(old bool) { storeNormalizedText = old }(storeNormalizedText)
+	storeNormalizedText = true
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	const text = "22-1 \n 1 MAIN ST \t DARTMOUTH\nSTRUCTURE\tFIRE\nE1   L4"
+	if err := im.process([]rawTweet{testTweet(10, text)}); err != nil {
+		t.Fatal(err)
+	}
+	var stored, normalized string
+	if err := db.QueryRow("select tweet_text, tweet_text_normalized from incidents where tweet_id = 10").Scan(&stored, &normalized); err != nil {
+		t.Fatal(err)
+	}
+	if stored != text || normalized != "22-1\n1 MAIN ST  DARTMOUTH\nSTRUCTURE FIRE\nE1  L4" {
+		t.Fatalf("stored %q normalized as %q", stored, normalized)
+	}
+	before := dumpTables(t, db)
+
+	// Reparsing works from the normalized text, and gets what parsing
+	// the original did.
+	if _, err := db.Exec("update incidents set tweet_text = 'garbled', type = 'OLD', parser_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	var errs bytes.Buffer
+	n, err := reparse(&errs, db, false, 0, reparseTables{})
+	if err != nil || n != 1 || errs.Len() != 0 {
+		t.Fatalf("reparse = %d, %v, reported %q, want 1 and nothing reported", n, err, errs.String())
+	}
+	if _, err := db.Exec("update incidents set tweet_text = ?", text); err != nil {
+		t.Fatal(err)
+	}
+	if after := dumpTables(t, db); after != before {
+		t.Errorf("reparsing the normalized text changed the tables from:\n%s\nto:\n%s", before, after)
+	}
+}
+
+func TestReparseEnrich(t *testing.T) {
+	old := enrichers
+	t.Cleanup(func() { enrichers = old })
+	enrichers = nil
+	// Spells out street suffixes, and rejects drills.
+	registerEnricher(func(in *Incident) error {
+		if in.IsTest {
+			return errors.New("no drills")
+		}
+		in.Location = strings.Replace(in.Location, " ST", " STREET", 1)
+		return nil
+	})
+
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1"),
+		testTweet(11, "22-2\n2 ELM ST  HALIFAX\nMEDICAL\nE3"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	location := func(id int64) string {
+		t.Helper()
+		var loc string
+		if err := db.QueryRow("select location from incidents where tweet_id = ?", id).Scan(&loc); err != nil {
+			t.Fatal(err)
+		}
+		return loc
+	}
+	if got := location(10); got != "1 MAIN STREET" {
+		t.Fatalf("stored location %q, want the enriched 1 MAIN STREET", got)
+	}
+
+	// Reparsing runs it again rather than undoing it.
+	var errs bytes.Buffer
+	if n, err := reparse(&errs, db, true, 0, reparseTables{}); err != nil || n != 2 || errs.Len() != 0 {
+		t.Fatalf("reparse = %d, %v, reported %q, want 2 and nothing reported", n, err, errs.String())
+	}
+	if got := location(10); got != "1 MAIN STREET" {
+		t.Errorf("location after reparsing %q, want 1 MAIN STREET", got)
+	}
+
+	// A row the enricher now rejects is reported and left alone.
+	if _, err := db.Exec("update incidents set tweet_text = ?, parser_version = 1 where tweet_id = 11", "22-2\n2 ELM ST  HALIFAX\nDRILL\nE3"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := reparse(&errs, db, false, 0, reparseTables{}); err != nil || n != 0 {
+		t.Fatalf("reparse = %d, %v, want 0", n, err)
+	}
+	if !strings.Contains(errs.String(), "tweet id=11: enrich: no drills") {
+		t.Errorf("reported %q, want the enrich error", errs.String())
+	}
+	if got := location(11); got != "2 ELM STREET" {
+		t.Errorf("rejected row's location %q, want it left as 2 ELM STREET", got)
+	}
+}
+
+func TestDumpUnparsed(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(20, "not an incident"),
+		testTweet(21, "22-1\n1 MAIN ST  DARTMOUTH"),
+		testTweet(22, "22-2\n2 MAIN ST  DARTMOUTH\nFIRE\nE1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Only a tweet's latest error counts, so one whose parse error was
+	// followed by another kind isn't listed.
+	if err := recordError(db, testTweet(23, "22-3"), errorClassParse, errors.New("bad tweet")); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordError(db, testTweet(23, "22-3"), errorClassCreatedAt, errors.New("bad time")); err != nil {
+		t.Fatal(err)
+	}
+
+	// What recordError wrote, latest first.
+	rows, err := db.Query("select tweet_id, message, tweet_text, recorded_at from processing_errors where class = ? and tweet_id < 23 order by recorded_at desc, tweet_id desc", errorClassParse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for rows.Next() {
+		var (
+			tweetID    int64
+			msg, text  string
+			recordedAt time.Time
+		)
+		if err := rows.Scan(&tweetID, &msg, &text, &recordedAt); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, fmt.Sprintf("tweet id=%v recorded %s: %s\n%s\n\n", tweetID, recordedAt.Format("2006-01-02 15:04 MST"), msg, text))
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !strings.HasPrefix(entries[0], "tweet id=21 ") || !strings.Contains(entries[0], "bad tweet with 2 lines") {
+		t.Fatalf("recorded %q, want parse errors for 21 and 20", entries)
+	}
+
+	var out bytes.Buffer
+	if err := dumpUnparsed(&out, db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), strings.Join(entries, ""); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	out.Reset()
+	if err := dumpUnparsed(&out, db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != entries[0] {
+		t.Errorf("with a limit of 1 got:\n%s\nwant:\n%s", got, entries[0])
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// seedTweets are sample tweets in the layouts the account has used, which
+// -seed stores for trying out the reports and exports without API access.
+// Their IDs are far below any real tweet's, so they can't collide with
+// fetched ones.
+var seedTweets = []rawTweet{
+	seedTweet(1, "2022-06-01T13:04:00Z", "22-12345\n123 MAIN ST  DARTMOUTH\nFIRE ALARM - COMMERCIAL\nE3 L4 STN3"),
+	seedTweet(2, "2022-06-01T15:30:00Z", "22-12346\nPORTLAND ST &amp; PLEASANT ST  DARTMOUTH\nMVC - WITH INJURIES\nE12 R2 STN12"),
+	seedTweet(3, "2022-06-02T02:58:00Z", "22-12347\n6 BEDFORD HWY, BEDFORD\nMEDICAL - Transported @ 23:58\nUnits: E9"),
+	seedTweet(4, "2022-06-02T18:12:00Z", "22-12348\n10 LAKEVIEW RD  LOWER SACKVILLE/FALL RIVER\nSTRUCTURE FIRE\nE20 E21 T14 STN20 STN21"),
+	seedTweet(5, "2022-06-02T18:40:00Z", "22-12348\n10 LAKEVIEW RD  LOWER SACKVILLE/FALL RIVER\nSTRUCTURE FIRE\nE20 E21 T14 L4 STN20 STN21"),
+	seedTweet(6, "2022-06-03T09:15:00Z", "22-12349\n1 MAIN ST  DARTMOUTH\nDRILL\nSTN5"),
+	seedTweet(7, "2022-06-03T21:47:00Z", "22-12350\n5571 SPRING GARDEN RD  HALIFAX\nMEDICAL\nE4"),
+	seedTweet(8, "2022-06-04T04:05:00Z", "22-12351\n200 COBEQUID RD  LOWER SACKVILLE\nVEHICLE FIRE\nE20"),
+	seedTweet(9, "2022-06-04T16:20:00Z", "22-12352\nHWY 102 NB EXIT 4  BEDFORD\nMVC\nE9 R9 STN9"),
+	seedTweet(10, "2022-06-05T11:33:00Z", "22-12353\n45 ALDERNEY DR  DARTMOUTH\nHAZMAT - GAS LEAK\nE3 HAZ1"),
+}
+
+// seedTweet returns a tweet of text with id, created at the RFC 3339 time
+// created.
+func seedTweet(id int64, created, text string) rawTweet {
+	t, err := time.Parse(time.RFC3339, created)
+	if err != nil {
+		panic(err)
+	}
+	return rawTweet{ID: id, Text: text, Created: t, CreatedRaw: t.Format(time.RubyDate), Lang: "en"}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestSeed(t *testing.T) {
+	db := newTestDB(t)
+	// Seeding again is a no-op.
+	for i := 0; i < 2; i++ {
+		im := &importer{db: db, quiet: true}
+		if err := im.process(seedTweets); err != nil {
+			t.Fatal(err)
+		}
+		if im.parseFailures != 0 {
+			t.Errorf("seeding %d: %d tweets failed to parse", i+1, im.parseFailures)
+		}
+	}
+	if n := countRows(t, db, "incidents"); n != len(seedTweets) {
+		t.Errorf("got %d incidents, want %d", n, len(seedTweets))
+	}
+
+	want := make(map[int64]Incident)
+	for _, tw := range seedTweets {
+		in, err := parse(tw.Text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[tw.ID] = in
+	}
+	if err := eachIncident(db, "", nil, func(in Incident) error {
+		w := want[in.TweetID]
+		if in.ID != w.ID || in.Location != w.Location || in.Type != w.Type || in.TypeInferred || in.IsTest != w.IsTest {
+			t.Errorf("tweet %d stored as %+v, want %+v", in.TweetID, in, w)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
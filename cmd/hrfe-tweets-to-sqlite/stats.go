@@ -0,0 +1,566 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+
+	"golang.org/x/exp/maps"
+)
+
+// sparkDays is how many days of history the stats sparkline covers.
+const sparkDays = 30
+
+// printStats writes a short summary of the stored incidents to w, including
+// how many have no known apparatus and the daily volume over the sparkDays
+// days up to and including now's day in the display time zone.
+func printStats(w io.Writer, db *sql.DB, now time.Time, unicode bool) error {
+	var total int
+	if err := db.QueryRow("select count(*) from incidents where " + reportWhere("")).Scan(&total); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "incidents: %d\n", total)
+
+	// Incidents tweeted with only stations were dispatched without the
+	// units being known.
+	var stationOnly int
+	if err := db.QueryRow("select count(*) from incidents where " + reportWhere("apparatus_count = 0")).Scan(&stationOnly); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "no known apparatus: %d\n", stationOnly)
+
+	now = local(now)
+	start := time.Date(now.Year(), now.Month(), now.Day()-sparkDays+1, 0, 0, 0, 0, displayLoc)
+
+	rows, err := db.Query("select created_at from incidents where "+reportWhere("created_at >= ?"), start.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var times []time.Time
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return err
+		}
+		times = append(times, t)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	counts := dayBuckets(times, now, sparkDays)
+	if unicode {
+		fmt.Fprintf(w, "last %d days: %s\n", sparkDays, sparkline(counts))
+	} else {
+		fmt.Fprintf(w, "last %d days: %s\n", sparkDays, joinInts(counts))
+	}
+	return nil
+}
+
+// dayBuckets counts times by calendar day in end's location, returning days
+// counts with the last being end's day. Times outside the range are ignored.
+func dayBuckets(times []time.Time, end time.Time, days int) []int {
+	counts := make([]int, days)
+	endDay := civilDay(end)
+	for _, t := range times {
+		ago := int(endDay.Sub(civilDay(t.In(end.Location()))) / (24 * time.Hour))
+		if ago < 0 || ago >= days {
+			continue
+		}
+		counts[days-1-ago]++
+	}
+	return counts
+}
+
+// civilDay returns t's calendar date as midnight UTC, so subtracting two of
+// them gives a whole number of days regardless of DST changes.
+func civilDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders counts as block characters scaled to the largest count.
+func sparkline(counts []int) string {
+	max := 0
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+	var b strings.Builder
+	for _, c := range counts {
+		i := 0
+		if max > 0 {
+			i = c * (len(sparkBlocks) - 1) / max
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
+
+func joinInts(ns []int) string {
+	ss := make([]string, len(ns))
+	for i, n := range ns {
+		ss[i] = strconv.Itoa(n)
+	}
+	return strings.Join(ss, " ")
+}
+
+// printTypesByMonth writes a table with a row per month (display time) and a
+// column for each of the top most common incident types overall, counting
+// the rest as "other". With asCSV the table is written as CSV instead of
+// aligned text.
+func printTypesByMonth(w io.Writer, db *sql.DB, top int, asCSV bool) error {
+	totals := make(map[string]int)
+	byMonth := make(map[string]map[string]int)
+	if err := eachIncident(db, reportWhere(""), nil, func(in Incident) error {
+		month := local(in.CreatedAt).Format("2006-01")
+		if byMonth[month] == nil {
+			byMonth[month] = make(map[string]int)
+		}
+		byMonth[month][in.Type]++
+		totals[in.Type]++
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	types := maps.Keys(totals)
+	sort.Slice(types, func(i, j int) bool {
+		if totals[types[i]] != totals[types[j]] {
+			return totals[types[i]] > totals[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	other := len(types) > top
+	if other {
+		types = types[:top]
+	}
+
+	header := append([]string{"month"}, types...)
+	if other {
+		header = append(header, "other")
+	}
+	table := [][]string{header}
+
+	months := maps.Keys(byMonth)
+	sort.Strings(months)
+	for _, m := range months {
+		counts := byMonth[m]
+		row := []string{m}
+		rest := 0
+		for _, n := range counts {
+			rest += n
+		}
+		for _, t := range types {
+			row = append(row, strconv.Itoa(counts[t]))
+			rest -= counts[t]
+		}
+		if other {
+			row = append(row, strconv.Itoa(rest))
+		}
+		table = append(table, row)
+	}
+
+	return writeTable(w, table, asCSV)
+}
+
+// includeTests is whether reports count drills and test messages. Set from
+// flags.
+var includeTests bool
+
+// reportWhere returns the condition for incidents to include in reports,
+// those matching where, if any, and not tests unless includeTests.
+func reportWhere(where string) string {
+	var conds []string
+	if where != "" {
+		conds = append(conds, "("+where+")")
+	}
+	if !includeTests {
+		conds = append(conds, "not is_test")
+	}
+	if len(conds) == 0 {
+		return "true"
+	}
+	return strings.Join(conds, " and ")
+}
+
+// csvBOM is whether CSV output starts with a UTF-8 byte order mark, which
+// Excel needs to read it as UTF-8. Set from flags.
+var csvBOM bool
+
+// writeTable writes rows, the first being the header, as tab-aligned text or
+// as CSV.
+func writeTable(w io.Writer, rows [][]string, asCSV bool) error {
+	if asCSV {
+		if csvBOM {
+			if _, err := io.WriteString(w, "\ufeff"); err != nil {
+				return err
+			}
+		}
+		cw := csv.NewWriter(w)
+		cw.WriteAll(rows)
+		return cw.Error()
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// printBusiestDays writes the n calendar days (display time) with the most
+// incidents, along with each day's counts by type.
+func printBusiestDays(w io.Writer, db *sql.DB, n int, asCSV bool) error {
+	byDay := make(map[string][]Incident)
+	if err := eachIncident(db, reportWhere(""), nil, func(in Incident) error {
+		day := local(in.CreatedAt).Format("2006-01-02")
+		byDay[day] = append(byDay[day], in)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	days := maps.Keys(byDay)
+	sort.Slice(days, func(i, j int) bool {
+		if len(byDay[days[i]]) != len(byDay[days[j]]) {
+			return len(byDay[days[i]]) > len(byDay[days[j]])
+		}
+		return days[i] > days[j]
+	})
+	if len(days) > n {
+		days = days[:n]
+	}
+
+	table := [][]string{{"day", "incidents", "types"}}
+	for _, d := range days {
+		var types []string
+		for _, tc := range countTypes(byDay[d]) {
+			types = append(types, fmt.Sprintf("%s %d", tc.Type, tc.Count))
+		}
+		table = append(table, []string{d, strconv.Itoa(len(byDay[d])), strings.Join(types, ", ")})
+	}
+	return writeTable(w, table, asCSV)
+}
+
+// printLast writes the n most recent incidents, oldest first so the newest
+// ends up nearest the prompt.
+func printLast(w io.Writer, db *sql.DB, n int, asCSV bool) error {
+	table := [][]string{{"time", "id", "type", "location", "community", "apparatus"}}
+	if err := eachIncident(db, "tweet_id in (select tweet_id from incidents where "+reportWhere("")+" order by "+incidentOrderDesc+" limit ?)", []any{n}, func(in Incident) error {
+		table = append(table, []string{
+			local(in.CreatedAt).Format("2006-01-02 15:04 MST"),
+			in.ID,
+			in.Type,
+			in.Location,
+			in.Community,
+			strings.Join(append(in.Apparatuses, in.Stations...), " "),
+		})
+		return nil
+	}); err != nil {
+		return err
+	}
+	return writeTable(w, table, asCSV)
+}
+
+// period is a range of whole days in the display time zone, from start up to
+// but not including end.
+type period struct {
+	start, end time.Time
+}
+
+// parsePeriod parses an inclusive range of days like 2022-01-01..2022-01-31,
+// or a single day.
+func parsePeriod(s string) (period, error) {
+	from, to, ok := strings.Cut(s, "..")
+	if !ok {
+		to = from
+	}
+	start, err := time.ParseInLocation("2006-01-02", from, displayLoc)
+	if err != nil {
+		return period{}, err
+	}
+	last, err := time.ParseInLocation("2006-01-02", to, displayLoc)
+	if err != nil {
+		return period{}, err
+	}
+	if last.Before(start) {
+		return period{}, fmt.Errorf("%s ends before it starts", s)
+	}
+	return period{start, last.AddDate(0, 0, 1)}, nil
+}
+
+func (p period) String() string {
+	return p.start.Format("2006-01-02") + ".." + p.end.AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+func countByType(db *sql.DB, p period) (map[string]int, error) {
+	counts := make(map[string]int)
+	err := eachIncident(db, reportWhere("created_at >= ? and created_at < ?"), []any{p.start.UTC(), p.end.UTC()}, func(in Incident) error {
+		counts[in.Type]++
+		return nil
+	})
+	return counts, err
+}
+
+// printComparePeriods writes incident counts by type for periods a and b,
+// with the change from a to b.
+func printComparePeriods(w io.Writer, db *sql.DB, a, b period, asCSV bool) error {
+	ac, err := countByType(db, a)
+	if err != nil {
+		return err
+	}
+	bc, err := countByType(db, b)
+	if err != nil {
+		return err
+	}
+
+	types := maps.Keys(ac)
+	for t := range bc {
+		if _, ok := ac[t]; !ok {
+			types = append(types, t)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if ac[types[i]]+bc[types[i]] != ac[types[j]]+bc[types[j]] {
+			return ac[types[i]]+bc[types[i]] > ac[types[j]]+bc[types[j]]
+		}
+		return types[i] < types[j]
+	})
+
+	table := [][]string{{"type", a.String(), b.String(), "change", "%"}}
+	var atotal, btotal int
+	for _, t := range types {
+		table = append(table, compareRow(t, ac[t], bc[t]))
+		atotal += ac[t]
+		btotal += bc[t]
+	}
+	table = append(table, compareRow("total", atotal, btotal))
+	return writeTable(w, table, asCSV)
+}
+
+// compareRow formats counts a and b with the absolute and percentage change
+// between them. The percentage is n/a when a is zero.
+func compareRow(label string, a, b int) []string {
+	pct := "n/a"
+	if a != 0 {
+		pct = fmt.Sprintf("%+.1f%%", float64(b-a)*100/float64(a))
+	}
+	return []string{label, strconv.Itoa(a), strconv.Itoa(b), fmt.Sprintf("%+d", b-a), pct}
+}
+
+// printStationApparatus writes, for each station, the apparatus that have
+// responded to an incident alongside it, with how often, and the apparatus
+// that never have. It shows which units work out of which stations in
+// practice.
+func printStationApparatus(w io.Writer, db *sql.DB, asCSV bool) error {
+	with := make(map[string]map[string]int)
+	all := make(map[string]bool)
+	if err := eachIncident(db, reportWhere(""), nil, func(in Incident) error {
+		for _, a := range in.Apparatuses {
+			all[a] = true
+		}
+		for _, s := range in.Stations {
+			if with[s] == nil {
+				with[s] = make(map[string]int)
+			}
+			for _, a := range in.Apparatuses {
+				with[s][a]++
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	apparatuses := maps.Keys(all)
+	sort.Strings(apparatuses)
+	stations := maps.Keys(with)
+	sort.Strings(stations)
+
+	table := [][]string{{"station", "with", "never with"}}
+	for _, s := range stations {
+		var seen, never []string
+		for _, a := range apparatuses {
+			if n := with[s][a]; n > 0 {
+				seen = append(seen, fmt.Sprintf("%s %d", a, n))
+			} else {
+				never = append(never, a)
+			}
+		}
+		table = append(table, []string{s, strings.Join(seen, ", "), strings.Join(never, " ")})
+	}
+	return writeTable(w, table, asCSV)
+}
+
+// countByFields are the fields -count-by can group on, with how to get each
+// one's values from an incident. Time fields use the display time zone.
+var countByFields = map[string]func(Incident) []string{
+	"type":      func(in Incident) []string { return []string{in.Type} },
+	"community": func(in Incident) []string { return []string{in.Community} },
+	"station":   func(in Incident) []string { return in.Stations },
+	"apparatus": func(in Incident) []string { return in.Apparatuses },
+	"hour":      func(in Incident) []string { return []string{local(in.CreatedAt).Format("15")} },
+	"dow": func(in Incident) []string {
+		return []string{strconv.Itoa(int(local(in.CreatedAt).Weekday())) + " " + local(in.CreatedAt).Format("Mon")}
+	},
+	"month": func(in Incident) []string { return []string{local(in.CreatedAt).Format("2006-01")} },
+}
+
+// timeCountByFields are listed in time order rather than by count.
+var timeCountByFields = map[string]bool{"hour": true, "dow": true, "month": true}
+
+// countByFieldNames returns the names of countByFields, sorted.
+func countByFieldNames() []string {
+	names := maps.Keys(countByFields)
+	sort.Strings(names)
+	return names
+}
+
+// printCountBy writes incident counts grouped by field, one of
+// countByFields. Incidents with several stations or apparatus count once
+// for each.
+func printCountBy(w io.Writer, db *sql.DB, field string, asCSV bool) error {
+	values, ok := countByFields[field]
+	if !ok {
+		return fmt.Errorf("unknown field %q, want one of %s", field, strings.Join(countByFieldNames(), ", "))
+	}
+	counts := make(map[string]int)
+	if err := eachIncident(db, reportWhere(""), nil, func(in Incident) error {
+		for _, v := range values(in) {
+			counts[v]++
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	keys := maps.Keys(counts)
+	sort.Slice(keys, func(i, j int) bool {
+		if !timeCountByFields[field] && counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	table := [][]string{{field, "incidents"}}
+	for _, k := range keys {
+		table = append(table, []string{k, strconv.Itoa(counts[k])})
+	}
+	return writeTable(w, table, asCSV)
+}
+
+// printInventory writes every distinct value of field, "station" or
+// "apparatus", ever seen, sorted, with how many incidents it was on and the
+// days it was first and last seen. Unlike the reports it includes drills
+// and tests, which use the same units.
+func printInventory(w io.Writer, db *sql.DB, field string, asCSV bool) error {
+	values := countByFields[field]
+	type seen struct {
+		incidents   map[string]bool
+		first, last time.Time
+	}
+	byValue := make(map[string]*seen)
+	if err := eachIncident(db, "", nil, func(in Incident) error {
+		for _, v := range values(in) {
+			s := byValue[v]
+			if s == nil {
+				s = &seen{incidents: make(map[string]bool), first: in.CreatedAt, last: in.CreatedAt}
+				byValue[v] = s
+			}
+			s.incidents[in.ID] = true
+			if in.CreatedAt.Before(s.first) {
+				s.first = in.CreatedAt
+			}
+			if in.CreatedAt.After(s.last) {
+				s.last = in.CreatedAt
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	keys := maps.Keys(byValue)
+	sort.Strings(keys)
+	table := [][]string{{field, "incidents", "first seen", "last seen"}}
+	for _, k := range keys {
+		s := byValue[k]
+		table = append(table, []string{k, strconv.Itoa(len(s.incidents)), local(s.first).Format("2006-01-02"), local(s.last).Format("2006-01-02")})
+	}
+	return writeTable(w, table, asCSV)
+}
+
+// normalizeLocation reduces a location to a form that compares equal across
+// the small differences in how the same address gets written: upper case,
+// no punctuation and single spaces.
+func normalizeLocation(loc string) string {
+	loc = strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) {
+			return ' '
+		}
+		return unicode.ToUpper(r)
+	}, loc)
+	return strings.Join(strings.Fields(loc), " ")
+}
+
+// printRepeatLocations writes the locations with more than min incidents,
+// in p if it's not nil, most first, along with their counts by type. An
+// incident tweeted several times counts once.
+func printRepeatLocations(w io.Writer, db *sql.DB, min int, p *period, asCSV bool) error {
+	where, args := reportWhere(""), []any(nil)
+	if p != nil {
+		where, args = reportWhere("created_at >= ? and created_at < ?"), []any{p.start.UTC(), p.end.UTC()}
+	}
+
+	type place struct{ location, community string }
+	byPlace := make(map[place]map[string]Incident)
+	if err := eachIncident(db, where, args, func(in Incident) error {
+		k := place{normalizeLocation(in.Location), in.Community}
+		if byPlace[k] == nil {
+			byPlace[k] = make(map[string]Incident)
+		}
+		if _, ok := byPlace[k][in.ID]; !ok {
+			byPlace[k][in.ID] = in
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var places []place
+	for k, ins := range byPlace {
+		if len(ins) > min {
+			places = append(places, k)
+		}
+	}
+	sort.Slice(places, func(i, j int) bool {
+		a, b := places[i], places[j]
+		if len(byPlace[a]) != len(byPlace[b]) {
+			return len(byPlace[a]) > len(byPlace[b])
+		}
+		if a.location != b.location {
+			return a.location < b.location
+		}
+		return a.community < b.community
+	})
+
+	table := [][]string{{"location", "community", "incidents", "types"}}
+	for _, k := range places {
+		var types []string
+		for _, tc := range countTypes(maps.Values(byPlace[k])) {
+			types = append(types, fmt.Sprintf("%s %d", tc.Type, tc.Count))
+		}
+		table = append(table, []string{k.location, k.community, strconv.Itoa(len(byPlace[k])), strings.Join(types, ", ")})
+	}
+	return writeTable(w, table, asCSV)
+}
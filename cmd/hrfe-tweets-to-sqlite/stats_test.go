@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDayBuckets(t *testing.T) {
+	halifax := mustLoadLocation("America/Halifax")
+	end := time.Date(2022, 3, 15, 9, 0, 0, 0, halifax)
+	times := []time.Time{
+		// 23:30 on the 14th in Halifax, though the 15th in UTC.
+		time.Date(2022, 3, 15, 2, 30, 0, 0, time.UTC),
+		time.Date(2022, 3, 15, 8, 0, 0, 0, halifax),
+		time.Date(2022, 3, 15, 8, 30, 0, 0, halifax),
+		time.Date(2022, 3, 12, 12, 0, 0, 0, halifax),
+		// Too old to count.
+		time.Date(2022, 3, 10, 12, 0, 0, 0, halifax),
+	}
+
+	got := dayBuckets(times, end, 5)
+	want := []int{0, 1, 0, 1, 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dayBuckets = %v, want %v", got, want)
+	}
+}
+
+// setDisplayLoc sets the display time zone to the named one until the test
+// ends.
+func setDisplayLoc(t *testing.T, name string) {
+	t.Helper()
+	old := displayLoc
+	displayLoc = mustLoadLocation(name)
+	t.Cleanup(func() { displayLoc = old })
+}
+
+// insertAt stores an incident of type typ for tweet id, created at at.
+func insertAt(t *testing.T, db *sql.DB, id int64, typ string, at time.Time) {
+	t.Helper()
+	in := Incident{ID: fmt.Sprintf("22-%d", id), Location: fmt.Sprintf("%d MAIN ST", id), Type: typ, TweetID: id, CreatedAt: at.UTC()}
+	if _, err := insertIncident(db, in); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDayBucketsNewYear(t *testing.T) {
+	halifax := mustLoadLocation("America/Halifax")
+	end := time.Date(2023, 1, 1, 9, 0, 0, 0, halifax)
+	times := []time.Time{
+		time.Date(2022, 12, 30, 12, 0, 0, 0, halifax),
+		time.Date(2022, 12, 31, 23, 59, 0, 0, halifax),
+		// 23:30 on the 31st in Halifax, though already 2023 in UTC.
+		time.Date(2023, 1, 1, 3, 30, 0, 0, time.UTC),
+		time.Date(2023, 1, 1, 0, 1, 0, 0, halifax),
+	}
+	if got, want := dayBuckets(times, end, 3), []int{1, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dayBuckets = %v, want %v", got, want)
+	}
+}
+
+func TestDayBucketsDST(t *testing.T) {
+	halifax := mustLoadLocation("America/Halifax")
+	for _, tt := range []struct {
+		name  string
+		day   int // of the change, in 2022
+		month time.Month
+	}{
+		// Clocks go from 02:00 to 03:00, a 23 hour day.
+		{"spring forward", 13, time.March},
+		// Clocks go from 02:00 back to 01:00, a 25 hour day.
+		{"fall back", 6, time.November},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			day := func(d, h, m int) time.Time { return time.Date(2022, tt.month, d, h, m, 0, 0, halifax) }
+			end := day(tt.day+1, 0, 30)
+			times := []time.Time{
+				day(tt.day-1, 23, 59),
+				day(tt.day, 0, 0),
+				day(tt.day, 1, 30),
+				day(tt.day, 3, 30),
+				day(tt.day, 23, 59),
+				day(tt.day+1, 0, 0),
+			}
+			if got, want := dayBuckets(times, end, 3), []int{1, 4, 1}; !reflect.DeepEqual(got, want) {
+				t.Errorf("dayBuckets = %v, want %v", got, want)
+			}
+
+			// An end late on the change day itself counts the same.
+			if got, want := dayBuckets(times, day(tt.day, 23, 59), 2), []int{1, 4}; !reflect.DeepEqual(got, want) {
+				t.Errorf("dayBuckets ending on the change day = %v, want %v", got, want)
+			}
+		})
+	}
+
+	setDisplayLoc(t, "America/Halifax")
+	// Either side of both changes, including both 01:30s when the clocks
+	// fall back.
+	for utc, want := range map[time.Time]string{
+		time.Date(2022, 3, 14, 3, 30, 0, 0, time.UTC): "2022-03-14 00:30 ADT",
+		time.Date(2022, 3, 13, 3, 30, 0, 0, time.UTC): "2022-03-12 23:30 AST",
+		time.Date(2022, 11, 6, 4, 30, 0, 0, time.UTC): "2022-11-06 01:30 ADT",
+		time.Date(2022, 11, 6, 5, 30, 0, 0, time.UTC): "2022-11-06 01:30 AST",
+		time.Date(2022, 11, 7, 3, 30, 0, 0, time.UTC): "2022-11-06 23:30 AST",
+	} {
+		if got := local(utc).Format("2006-01-02 15:04 MST"); got != want {
+			t.Errorf("local(%v) = %s, want %s", utc, got, want)
+		}
+	}
+}
+
+func TestTypesByMonthNewYear(t *testing.T) {
+	setDisplayLoc(t, "America/Halifax")
+	db := newTestDB(t)
+	halifax := displayLoc
+	insertAt(t, db, 1, "FIRE", time.Date(2022, 12, 31, 12, 0, 0, 0, halifax))
+	insertAt(t, db, 2, "FIRE", time.Date(2022, 12, 31, 23, 30, 0, 0, halifax))
+	insertAt(t, db, 3, "MEDICAL", time.Date(2023, 1, 1, 0, 30, 0, 0, halifax))
+
+	var out bytes.Buffer
+	if err := printTypesByMonth(&out, db, 5, true); err != nil {
+		t.Fatal(err)
+	}
+	want := "month,FIRE,MEDICAL\n2022-12,2,0\n2023-01,0,1\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBusiestDaysLocal(t *testing.T) {
+	setDisplayLoc(t, "America/Halifax")
+	db := newTestDB(t)
+	// Late evenings in Halifax are already the next day in UTC.
+	insertAt(t, db, 1, "FIRE", time.Date(2022, 3, 5, 1, 0, 0, 0, time.UTC))
+	insertAt(t, db, 2, "FIRE", time.Date(2022, 3, 5, 3, 59, 0, 0, time.UTC))
+	insertAt(t, db, 3, "MEDICAL", time.Date(2022, 3, 4, 12, 0, 0, 0, time.UTC))
+	insertAt(t, db, 4, "MEDICAL", time.Date(2022, 3, 5, 4, 0, 0, 0, time.UTC))
+	insertAt(t, db, 5, "MVC", time.Date(2022, 3, 5, 12, 0, 0, 0, time.UTC))
+
+	var out bytes.Buffer
+	if err := printBusiestDays(&out, db, 2, true); err != nil {
+		t.Fatal(err)
+	}
+	want := "day,incidents,types\n2022-03-04,3,\"FIRE 2, MEDICAL 1\"\n2022-03-05,2,\"MEDICAL 1, MVC 1\"\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDayBucketsEmpty(t *testing.T) {
+	got := dayBuckets(nil, testTime, 3)
+	if want := []int{0, 0, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dayBuckets = %v, want %v", got, want)
+	}
+	if s := sparkline(got); s != "▁▁▁" {
+		t.Errorf("sparkline = %q, want all lowest blocks", s)
+	}
+	if s := joinInts(got); s != "0 0 0" {
+		t.Errorf("joinInts = %q, want zeros", s)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	if s, want := sparkline([]int{0, 7, 14}), "▁▄█"; s != want {
+		t.Errorf("sparkline = %q, want %q", s, want)
+	}
+}
+
+func TestWriteTableBOM(t *testing.T) {
+	rows := [][]string{{"type", "count"}, {"FIRE, STRUCTURE", "2"}}
+	defer func(old bool) { csvBOM = old }(csvBOM)
+
+	for _, bom := range []bool{false, true} {
+		csvBOM = bom
+		var out bytes.Buffer
+		if err := writeTable(&out, rows, true); err != nil {
+			t.Fatal(err)
+		}
+		if has := bytes.HasPrefix(out.Bytes(), []byte("\ufeff")); has != bom {
+			t.Errorf("with csvBOM %v got BOM %v in %q", bom, has, out.String())
+		}
+		got, err := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(out.Bytes(), []byte("\ufeff")))).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, rows) {
+			t.Errorf("with csvBOM %v read back %q, want %q", bom, got, rows)
+		}
+	}
+
+	// Never for the aligned text tables.
+	csvBOM = true
+	var out bytes.Buffer
+	if err := writeTable(&out, rows, false); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(out.Bytes(), []byte("\ufeff")) {
+		t.Errorf("got a BOM in %q", out.String())
+	}
+}
+
+func TestStationOnlyIncidents(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nSTN5"),
+		testTweet(11, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nSTN5 STN6"),
+		testTweet(12, "22-2\n2 ELM ST  HALIFAX\nMEDICAL\nE3 STN3"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var count sql.NullInt64
+	if err := db.QueryRow("select apparatus_count from incidents where tweet_id = 10").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if !count.Valid || count.Int64 != 0 {
+		t.Errorf("apparatus_count = %v, want 0", count)
+	}
+
+	var out bytes.Buffer
+	if err := printStats(&out, db, testTime, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "no known apparatus: 2\n") {
+		t.Errorf("stats don't count the station-only incidents:\n%s", out.String())
+	}
+
+	// The merged view copes with nothing to merge.
+	var ins []Incident
+	if err := eachIncidentIn(db, "incidents_by_id", "", nil, func(in Incident) error {
+		ins = append(ins, in)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ins) != 2 || len(ins[0].Apparatuses) != 0 || !reflect.DeepEqual(ins[0].Stations, []string{"STN5", "STN6"}) {
+		t.Errorf("incidents_by_id gave %+v", ins)
+	}
+
+	out.Reset()
+	if err := printCountBy(&out, db, "apparatus", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(out.String()); !reflect.DeepEqual(got, []string{"apparatus", "incidents", "E3", "1"}) {
+		t.Errorf("count by apparatus = %q", got)
+	}
+	out.Reset()
+	if err := printStationApparatus(&out, db, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "STN5") {
+		t.Errorf("station apparatus report left out STN5:\n%s", out.String())
+	}
+}
+
+func TestComparePeriods(t *testing.T) {
+	db := newTestDB(t)
+	for i, day := range []int{3, 3, 3, 4, 4, 4} {
+		typ := "FIRE"
+		if i%3 == 2 {
+			typ = "MEDICAL"
+		}
+		insertAt(t, db, int64(i+1), typ, time.Date(2022, 3, day, 12, 0, 0, 0, time.UTC))
+	}
+	compare := func(a, b string) string {
+		t.Helper()
+		pa, err := parsePeriod(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pb, err := parsePeriod(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := printComparePeriods(&out, db, pa, pb, true); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	for _, tt := range []struct {
+		name, a, b, want string
+	}{
+		{"unchanged", "2022-03-03", "2022-03-04", `type,2022-03-03..2022-03-03,2022-03-04..2022-03-04,change,%
+FIRE,2,2,+0,+0.0%
+MEDICAL,1,1,+0,+0.0%
+total,3,3,+0,+0.0%
+`},
+		{"from nothing", "2022-03-10", "2022-03-04", `type,2022-03-10..2022-03-10,2022-03-04..2022-03-04,change,%
+FIRE,0,2,+2,n/a
+MEDICAL,0,1,+1,n/a
+total,0,3,+3,n/a
+`},
+		{"to nothing", "2022-03-03..2022-03-04", "2022-03-10..2022-03-11", `type,2022-03-03..2022-03-04,2022-03-10..2022-03-11,change,%
+FIRE,4,0,-4,-100.0%
+MEDICAL,2,0,-2,-100.0%
+total,6,0,-6,-100.0%
+`},
+		{"both empty", "2022-03-10", "2022-03-11", `type,2022-03-10..2022-03-10,2022-03-11..2022-03-11,change,%
+total,0,0,+0,n/a
+`},
+	} {
+		if got := compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrintLast(t *testing.T) {
+	db := newTestDB(t)
+	insertAt(t, db, 1, "FIRE", testTime.Add(-2*time.Hour))
+	insertAt(t, db, 5, "FIRE", testTime.Add(-time.Hour))
+	// The newest two in the same second, stored out of order.
+	insertAt(t, db, 4, "MEDICAL", testTime)
+	insertAt(t, db, 3, "MVC", testTime)
+	insertAt(t, db, 2, "FIRE", testTime.Add(-3*time.Hour))
+
+	for _, tt := range []struct {
+		n    int
+		want []string
+	}{
+		{3, []string{"22-5", "22-3", "22-4"}},
+		{1, []string{"22-4"}},
+		{10, []string{"22-2", "22-1", "22-5", "22-3", "22-4"}},
+	} {
+		var out bytes.Buffer
+		if err := printLast(&out, db, tt.n, true); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(&out).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, row := range rows[1:] {
+			got = append(got, row[1])
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("last %d: got %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestReportsLeaveOutTests(t *testing.T) {
+	db := newTestDB(t)
+	for id, isTest := range map[int64]bool{1: false, 2: false, 3: true} {
+		in := Incident{ID: fmt.Sprintf("22-%d", id), Location: "1 MAIN ST", Type: "FIRE", TweetID: id, CreatedAt: testTime, IsTest: isTest}
+		if _, err := insertIncident(db, in); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := includeTests
+	t.Cleanup(func() { includeTests = old })
+	for _, tt := range []struct {
+		includeTests bool
+		want         int
+	}{
+		{false, 2},
+		{true, 3},
+	} {
+		includeTests = tt.includeTests
+		var out bytes.Buffer
+		if err := printStats(&out, db, testTime, false); err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("incidents: %d\n", tt.want); !strings.HasPrefix(out.String(), want) {
+			t.Errorf("with includeTests %v, stats start:\n%s\nwant %q", tt.includeTests, out.String(), want)
+		}
+		out.Reset()
+		if err := printCountBy(&out, db, "type", false); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.Fields(out.String()), []string{"type", "incidents", "FIRE", fmt.Sprint(tt.want)}; !reflect.DeepEqual(got, want) {
+			t.Errorf("with includeTests %v, count by type = %q, want %q", tt.includeTests, got, want)
+		}
+	}
+}
+
+func TestNormalizeLocation(t *testing.T) {
+	for loc, want := range map[string]string{
+		"1 MAIN ST":             "1 MAIN ST",
+		"1 Main St.":            "1 MAIN ST",
+		" 1  MAIN   ST ":        "1 MAIN ST",
+		"PORTLAND ST & MAIN ST": "PORTLAND ST MAIN ST",
+		"PORTLAND ST/MAIN ST":   "PORTLAND ST MAIN ST",
+		"O'CONNELL DR, APT #2":  "O CONNELL DR APT 2",
+	} {
+		if got := normalizeLocation(loc); got != want {
+			t.Errorf("normalizeLocation(%q) = %q, want %q", loc, got, want)
+		}
+	}
+}
+
+func TestPrintRepeatLocations(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(10, "22-1\n1 Main St.  DARTMOUTH\nFIRE\nE1"),
+		// The same incident again counts once.
+		testTweet(11, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1 L4"),
+		testTweet(12, "22-2\n1 MAIN ST  DARTMOUTH\nMEDICAL\nE1"),
+		testTweet(13, "22-3\n1 main st  DARTMOUTH\nMEDICAL\nE1"),
+		// The same address in another community is another place.
+		testTweet(14, "22-4\n1 MAIN ST  HALIFAX\nFIRE\nE1"),
+		testTweet(15, "22-5\n2 ELM ST  DARTMOUTH\nFIRE\nE1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := printRepeatLocations(&out, db, 1, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	want := "location,community,incidents,types\n1 MAIN ST,DARTMOUTH,3,\"MEDICAL 2, FIRE 1\"\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPrintInventory(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	tweets := []rawTweet{
+		testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE3 L4 STN3"),
+		// The same incident again counts once.
+		testTweet(11, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE3 L4 E12 STN3 STN12"),
+		testTweet(12, "22-2\n2 ELM ST  HALIFAX\nMEDICAL\nE12"),
+		testTweet(13, "22-3\n3 OAK ST  DARTMOUTH\nDRILL\nE3 STN3"),
+	}
+	tweets[3].Created = testTime.AddDate(0, 0, 2)
+	if err := im.process(tweets); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range []string{"station", "apparatus"} {
+		// The sets parse gives, by incident.
+		want := make(map[string]map[string]bool)
+		for _, tw := range tweets {
+			in, err := parse(tw.Text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range countByFields[field](in) {
+				if want[v] == nil {
+					want[v] = make(map[string]bool)
+				}
+				want[v][in.ID] = true
+			}
+		}
+
+		var out bytes.Buffer
+		if err := printInventory(&out, db, field, true); err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(&out).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(records[0], []string{field, "incidents", "first seen", "last seen"}) {
+			t.Errorf("%s header %q", field, records[0])
+		}
+		got := make(map[string]string)
+		var names []string
+		for _, r := range records[1:] {
+			got[r[0]] = r[1]
+			names = append(names, r[0])
+		}
+		if !sort.StringsAreSorted(names) {
+			t.Errorf("%s inventory not sorted: %q", field, names)
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s inventory %q, want %d entries", field, names, len(want))
+		}
+		for v, ids := range want {
+			if got[v] != fmt.Sprint(len(ids)) {
+				t.Errorf("%s %s on %s incidents, want %d", field, v, got[v], len(ids))
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if err := printInventory(&out, db, "station", false); err != nil {
+		t.Fatal(err)
+	}
+	want := `station  incidents  first seen  last seen
+STN12    1          2022-03-04  2022-03-04
+STN3     2          2022-03-04  2022-03-06
+`
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
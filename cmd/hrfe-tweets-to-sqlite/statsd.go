@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// runMetrics are the numbers reported to statsd at the end of a fetch.
+type runMetrics struct {
+	processed     int // tweets handled, whatever the outcome
+	inserted      int // new incident rows
+	parseFailures int
+	apiCalls      int
+}
+
+// statsdLines formats m as statsd counters, plus a gauge of rows inserted,
+// with each name prefixed by prefix.
+func statsdLines(prefix string, m runMetrics) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%stweets_processed:%d|c\n", prefix, m.processed)
+	fmt.Fprintf(&b, "%sparse_failures:%d|c\n", prefix, m.parseFailures)
+	fmt.Fprintf(&b, "%sapi_calls:%d|c\n", prefix, m.apiCalls)
+	fmt.Fprintf(&b, "%srows_inserted:%d|g\n", prefix, m.inserted)
+	return b.String()
+}
+
+// sendStatsd sends m to the statsd server at addr in a single UDP packet.
+func sendStatsd(addr, prefix string, m runMetrics) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(statsdLines(prefix, m)))
+	return err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSendStatsd(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	m := runMetrics{processed: 12, inserted: 10, parseFailures: 2, apiCalls: 3}
+	if err := sendStatsd(pc.LocalAddr().String(), "hrfe.", m); err != nil {
+		t.Fatal(err)
+	}
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "hrfe.tweets_processed:12|c\nhrfe.parse_failures:2|c\nhrfe.api_calls:3|c\nhrfe.rows_inserted:10|g\n"
+	if got := string(buf[:n]); got != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jsonTweet is a tweet from newline-delimited JSON, as written by tools like
+// twarc. It accepts both API v1.1 and v2 field names and formats.
+type jsonTweet struct {
+	ID        json.RawMessage `json:"id"` // a number in v1.1, a string in v2
+	IDStr     string          `json:"id_str"`
+	FullText  string          `json:"full_text"`
+	Text      string          `json:"text"`
+	CreatedAt string          `json:"created_at"` // Ruby date in v1.1, RFC 3339 in v2
+	Lang      string          `json:"lang"`
+	Source    string          `json:"source"` // absent from v2 unless requested
+}
+
+// naiveLayouts are timestamp formats without a zone seen in exports from
+// other tools, interpreted in inputLoc.
+var naiveLayouts = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// parseCreatedAt parses a tweet creation time, either zoned or naive,
+// returning it in UTC.
+func parseCreatedAt(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RubyDate, time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), true
+		}
+	}
+	for _, layout := range naiveLayouts {
+		if t, err := time.ParseInLocation(layout, s, inputLoc); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// rawTweet converts jt, or returns an error if it has no usable ID or text.
+func (jt jsonTweet) rawTweet() (rawTweet, error) {
+	idStr := jt.IDStr
+	if idStr == "" {
+		idStr = strings.Trim(string(jt.ID), `"`)
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		return rawTweet{}, fmt.Errorf("bad id %q", idStr)
+	}
+	text := jt.FullText
+	if text == "" {
+		text = jt.Text
+	}
+	if text == "" {
+		return rawTweet{}, errors.New("no text")
+	}
+
+	tw := rawTweet{ID: id, Text: text, CreatedRaw: jt.CreatedAt, Lang: jt.Lang, Source: jt.Source}
+	if t, ok := parseCreatedAt(jt.CreatedAt); ok {
+		tw.Created = t
+	}
+	return tw, nil
+}
+
+// readJSONTweets processes newline-delimited JSON tweets from r in order, a
+// page's worth at a time. Malformed lines are logged and skipped. It returns
+// how many were skipped.
+func readJSONTweets(im *importer, r io.Reader) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	var (
+		page      []rawTweet
+		malformed int
+	)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var jt jsonTweet
+		if err := json.Unmarshal(sc.Bytes(), &jt); err != nil {
+			log.Printf("line %d: %v", line, err)
+			malformed++
+			continue
+		}
+		tw, err := jt.rawTweet()
+		if err != nil {
+			log.Printf("line %d: %v", line, err)
+			malformed++
+			continue
+		}
+		page = append(page, tw)
+		if len(page) == maxPageSize {
+			if err := im.process(page); err != nil {
+				return malformed, err
+			}
+			page = page[:0]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return malformed, err
+	}
+	if len(page) > 0 {
+		if err := im.process(page); err != nil {
+			return malformed, err
+		}
+	}
+	return malformed, nil
+}
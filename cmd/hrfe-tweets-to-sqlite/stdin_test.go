@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ndjsonTweets is newline-delimited JSON as exported by different tools,
+// with a couple of lines that can't be used.
+const ndjsonTweets = `{"id": 1500000000000000001, "id_str": "1500000000000000001", "full_text": "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1 L4", "created_at": "Fri Mar 04 15:30:00 +0000 2022", "lang": "en", "source": "<a href=\"https://about.twitter.com/products/tweetdeck\">TweetDeck</a>"}
+{"id": "1500000000000000002", "text": "22-2\n2 ELM ST  HALIFAX\nMEDICAL\nE3", "created_at": "2022-03-04T15:31:00.000Z", "lang": "en"}
+
+{"id": "1500000000000000003", "text": "22-3\n3 OAK ST  BEDFORD\nMVC\nE5", "created_at": "2022-03-04 11:32:00"}
+{"id": "1500000000000000004", "text": "
+{"id": "1500000000000000005", "created_at": "2022-03-04T15:34:00Z"}
+`
+
+func TestReadJSONTweets(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	malformed, err := readJSONTweets(im, strings.NewReader(ndjsonTweets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if malformed != 2 || im.inserted != 3 {
+		t.Errorf("malformed %d, inserted %d, want 2, 3", malformed, im.inserted)
+	}
+
+	want := map[int64]struct {
+		id, lang, source string
+		createdAt        time.Time
+	}{
+		1500000000000000001: {"22-1", "en", `<a href="https://about.twitter.com/products/tweetdeck">TweetDeck</a>`, time.Date(2022, 3, 4, 15, 30, 0, 0, time.UTC)},
+		1500000000000000002: {"22-2", "en", "", time.Date(2022, 3, 4, 15, 31, 0, 0, time.UTC)},
+		// Naive, so in Halifax time.
+		1500000000000000003: {"22-3", "", "", time.Date(2022, 3, 4, 15, 32, 0, 0, time.UTC)},
+	}
+	rows, err := db.Query("select tweet_id, id, tweet_lang, tweet_source, created_at from incidents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			tweetID          int64
+			id, lang, source string
+			createdAt        time.Time
+		)
+		if err := rows.Scan(&tweetID, &id, &lang, &source, &createdAt); err != nil {
+			t.Fatal(err)
+		}
+		w, ok := want[tweetID]
+		if !ok {
+			t.Errorf("unexpected tweet id=%v", tweetID)
+			continue
+		}
+		if id != w.id || lang != w.lang || source != w.source || !createdAt.Equal(w.createdAt) {
+			t.Errorf("tweet id=%v: got %q, %q, %q, %v, want %q, %q, %q, %v", tweetID, id, lang, source, createdAt, w.id, w.lang, w.source, w.createdAt)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseCreatedAt(t *testing.T) {
+	old := inputLoc
+	t.Cleanup(func() { inputLoc = old })
+
+	for s, want := range map[string]time.Time{
+		"Fri Mar 04 11:30:00 -0400 2022": time.Date(2022, 3, 4, 15, 30, 0, 0, time.UTC),
+		"2022-03-04T11:31:00-04:00":      time.Date(2022, 3, 4, 15, 31, 0, 0, time.UTC),
+		"2022-03-04T15:32:00.000Z":       time.Date(2022, 3, 4, 15, 32, 0, 0, time.UTC),
+	} {
+		got, ok := parseCreatedAt(s)
+		if !ok || got != want {
+			t.Errorf("parseCreatedAt(%q) = %v, %v, want %v", s, got, ok, want)
+		}
+	}
+
+	// A naive timestamp is taken to be in -tz-in.
+	for name, want := range map[string]time.Time{
+		"America/Halifax": time.Date(2022, 3, 4, 15, 33, 0, 0, time.UTC),
+		"Europe/London":   time.Date(2022, 3, 4, 11, 33, 0, 0, time.UTC),
+	} {
+		if err := setInputTZ(name); err != nil {
+			t.Fatal(err)
+		}
+		got, ok := parseCreatedAt("2022-03-04 11:33:00")
+		if !ok || got != want {
+			t.Errorf("in %s, got %v, %v, want %v", name, got, ok, want)
+		}
+	}
+
+	if _, ok := parseCreatedAt("yesterday"); ok {
+		t.Error("parsed yesterday")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// runSummary is written to -summary-file at the end of a fetch.
+type runSummary struct {
+	FinishedAt    time.Time `json:"finished_at"`
+	Processed     int       `json:"processed"`
+	Inserted      int       `json:"inserted"`
+	ParseFailures int       `json:"parse_failures"`
+	APICalls      int       `json:"api_calls"`
+	NewestTweetID int64     `json:"newest_tweet_id"`
+	OldestTweetID int64     `json:"oldest_tweet_id"`
+	StaleRuns     int       `json:"stale_runs,omitempty"`
+}
+
+// newRunSummary summarizes a run from its metrics and what's now stored.
+func newRunSummary(db *sql.DB, m runMetrics, stale int) (runSummary, error) {
+	s := runSummary{
+		FinishedAt:    time.Now().UTC(),
+		Processed:     m.processed,
+		Inserted:      m.inserted,
+		ParseFailures: m.parseFailures,
+		APICalls:      m.apiCalls,
+		StaleRuns:     stale,
+	}
+	var err error
+	if s.NewestTweetID, err = maxTweetID(db); err != nil {
+		return runSummary{}, err
+	}
+	if s.OldestTweetID, err = minTweetID(db); err != nil {
+		return runSummary{}, err
+	}
+	return s, nil
+}
+
+// writeSummary writes s as a line of JSON to path, replacing the file or,
+// with appendTo, adding to the end of it.
+func writeSummary(path string, s runSummary, appendTo bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendTo {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteSummary(t *testing.T) {
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{
+		testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1"),
+		testTweet(20, "22-2\n2 ELM ST  HALIFAX\nMEDICAL\nE3"),
+		testTweet(5, "not an incident"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	m := runMetrics{processed: im.processed, inserted: im.inserted, parseFailures: im.parseFailures, apiCalls: 2}
+	s, err := newRunSummary(db, m, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(s.FinishedAt) > time.Minute || s.FinishedAt.Location() != time.UTC {
+		t.Errorf("finished at %v, want about now in UTC", s.FinishedAt)
+	}
+	want := runSummary{FinishedAt: s.FinishedAt, Processed: 3, Inserted: 2, ParseFailures: 1, APICalls: 2, NewestTweetID: 20, OldestTweetID: 5, StaleRuns: 1}
+	if s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "summary.jsonl")
+	read := func() []runSummary {
+		t.Helper()
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var got []runSummary
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var s runSummary
+			if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+				t.Fatalf("line %q: %v", sc.Text(), err)
+			}
+			got = append(got, s)
+		}
+		return got
+	}
+
+	// Without appending each run replaces the file.
+	second := s
+	second.Inserted = 0
+	for _, sum := range []runSummary{s, second} {
+		if err := writeSummary(path, sum, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := read()
+	if len(got) != 1 || got[0].Inserted != 0 || !got[0].FinishedAt.Equal(s.FinishedAt) || got[0].OldestTweetID != 5 {
+		t.Errorf("after overwriting got %+v, want only the second summary", got)
+	}
+
+	// Appending adds a line a run.
+	if err := writeSummary(path, s, true); err != nil {
+		t.Fatal(err)
+	}
+	got = read()
+	if len(got) != 2 || got[0].Inserted != 0 || got[1].Inserted != 2 {
+		t.Errorf("after appending got %+v, want the second summary then the first", got)
+	}
+}
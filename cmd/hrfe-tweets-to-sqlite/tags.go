@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tagRule tags incidents whose tweet text matches it.
+type tagRule struct {
+	substr string         // matched ignoring case, when re is nil
+	re     *regexp.Regexp // matched as is
+	tag    string
+}
+
+func (r tagRule) matches(text string) bool {
+	if r.re != nil {
+		return r.re.MatchString(text)
+	}
+	return strings.Contains(strings.ToLower(text), strings.ToLower(r.substr))
+}
+
+// tagRules are applied to every incident stored, loaded from -tags.
+var tagRules []tagRule
+
+// tagsFor returns the tags of every rule matching tweet text, sorted and
+// without repeats.
+func tagsFor(text string) []string {
+	text = html.UnescapeString(text)
+	seen := make(map[string]bool)
+	var tags []string
+	for _, r := range tagRules {
+		if !seen[r.tag] && r.matches(text) {
+			seen[r.tag] = true
+			tags = append(tags, r.tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// loadTagRules reads a CSV file of pattern,tag rules. A pattern starting
+// with "re:" is a regular expression, anything else a substring matched
+// ignoring case. Lines starting with # are ignored. All bad lines are
+// reported together.
+func loadTagRules(path string) ([]tagRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var (
+		rules []tagRule
+		bad   []string
+	)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		if len(rec) != 2 || strings.TrimSpace(rec[0]) == "" || strings.TrimSpace(rec[1]) == "" {
+			bad = append(bad, fmt.Sprintf("line %d: want pattern,tag", line))
+			continue
+		}
+		rule := tagRule{tag: strings.TrimSpace(rec[1])}
+		if p := strings.TrimSpace(rec[0]); strings.HasPrefix(p, "re:") {
+			re, err := regexp.Compile(strings.TrimPrefix(p, "re:"))
+			if err != nil {
+				bad = append(bad, fmt.Sprintf("line %d: %v", line, err))
+				continue
+			}
+			rule.re = re
+		} else {
+			rule.substr = strings.TrimSpace(rec[0])
+		}
+		rules = append(rules, rule)
+	}
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("%s: %s", path, strings.Join(bad, "; "))
+	}
+	return rules, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// setTagRules loads rules from a file holding csv until the test ends.
+func setTagRules(t *testing.T, csv string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tags.csv")
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadTagRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := tagRules
+	tagRules = rules
+	t.Cleanup(func() { tagRules = old })
+}
+
+// tweetTags returns the tags stored for tweet id.
+func tweetTags(t *testing.T, db *sql.DB, id int64) []string {
+	t.Helper()
+	rows, err := db.Query("select tag from incident_tags where tweet_id = ? order by tag", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			t.Fatal(err)
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return tags
+}
+
+func TestTagsFor(t *testing.T) {
+	setTagRules(t, "# pattern,tag\nfire,fire\nre:\\bL[0-9]+\\b,ladder\nSTRUCTURE,fire\nhazmat,hazmat\n")
+
+	for text, want := range map[string][]string{
+		"22-1\n1 MAIN ST  DARTMOUTH\nSTRUCTURE FIRE\nE1 L4": {"fire", "ladder"},
+		"22-2\n1 MAIN ST  DARTMOUTH\nMEDICAL\nE1":           nil,
+		"22-3\n1 MAIN ST  DARTMOUTH\nHAZMAT\nE1 L10":        {"hazmat", "ladder"},
+	} {
+		if got := tagsFor(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("tagsFor(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestLoadTagRulesBad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tags.csv")
+	if err := os.WriteFile(path, []byte("fire\nre:(,bad\nok,ok\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTagRules(path); err == nil {
+		t.Error("loaded bad rules, want an error")
+	}
+}
+
+func TestReparseTags(t *testing.T) {
+	setTagRules(t, "fire,fire\n")
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process([]rawTweet{testTweet(10, "22-1\n1 MAIN ST  DARTMOUTH/COLE HARBOUR\nFIRE\nE1 L4")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := tweetTags(t, db, 10); !reflect.DeepEqual(got, []string{"fire"}) {
+		t.Fatalf("stored tags %q, want fire", got)
+	}
+
+	// Without the rules loaded, reparsing leaves the tags and communities
+	// alone.
+	tagRules = nil
+	if _, err := reparse(io.Discard, db, true, 0, reparseTables{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := tweetTags(t, db, 10); !reflect.DeepEqual(got, []string{"fire"}) {
+		t.Errorf("tags after reparsing without rules %q, want fire", got)
+	}
+	if n := countRows(t, db, "incident_communities"); n != 2 {
+		t.Errorf("got %d communities after reparsing, want 2", n)
+	}
+
+	// Changed rules are applied.
+	setTagRules(t, "fire,fire\nre:\\bL[0-9]+\\b,ladder\n")
+	if _, err := reparse(io.Discard, db, true, 0, reparseTables{tags: true, communities: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := tweetTags(t, db, 10); !reflect.DeepEqual(got, []string{"fire", "ladder"}) {
+		t.Errorf("tags after reparsing %q, want fire and ladder", got)
+	}
+	if n := countRows(t, db, "incident_communities"); n != 2 {
+		t.Errorf("got %d communities after reparsing, want 2", n)
+	}
+}
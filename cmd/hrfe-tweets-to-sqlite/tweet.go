@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// rawTweet is what process needs from a tweet, independent of the library
+// or source it came from.
+type rawTweet struct {
+	ID         int64
+	Text       string
+	Created    time.Time // zero if CreatedRaw couldn't be parsed
+	CreatedRaw string    // the creation time as the source gave it
+	Lang       string    // empty if unknown
+	Source     string    // the posting client, empty if unknown
+	Media      []rawMedia
+
+	// AuthorTweetCount is how many tweets the author has posted, if known.
+	AuthorTweetCount int
+}
+
+type rawMedia struct {
+	ID   int64
+	Type string
+	URL  string
+}
+
+// fromTwitter converts tweets from the Twitter API.
+func fromTwitter(tweets []twitter.Tweet) []rawTweet {
+	raws := make([]rawTweet, len(tweets))
+	for i, tw := range tweets {
+		raw := rawTweet{
+			ID:         tw.ID,
+			Text:       tw.FullText,
+			CreatedRaw: tw.CreatedAt,
+			Lang:       tw.Lang,
+			Source:     tw.Source,
+		}
+		if t, err := tw.CreatedAtTime(); err == nil {
+			raw.Created = t
+		}
+		for _, m := range tweetMedia(tw) {
+			raw.Media = append(raw.Media, rawMedia{ID: m.ID, Type: m.Type, URL: m.MediaURLHttps})
+		}
+		if tw.User != nil {
+			raw.AuthorTweetCount = tw.User.StatusesCount
+		}
+		raws[i] = raw
+	}
+	return raws
+}
+
+// tweetMedia returns the media attached to tw, preferring the extended
+// entities since those include every photo rather than just the first.
+func tweetMedia(tw twitter.Tweet) []twitter.MediaEntity {
+	if tw.ExtendedEntities != nil && len(tw.ExtendedEntities.Media) > 0 {
+		return tw.ExtendedEntities.Media
+	}
+	if tw.Entities != nil {
+		return tw.Entities.Media
+	}
+	return nil
+}
+
+// createdAt returns when tw was posted, or an error if the source's
+// timestamp couldn't be understood.
+func (tw rawTweet) createdAt() (time.Time, error) {
+	if !tw.Created.IsZero() {
+		return tw.Created, nil
+	}
+	// Fail the way the API's format does, as tweets always have.
+	_, err := time.Parse(time.RubyDate, tw.CreatedRaw)
+	if err == nil {
+		err = fmt.Errorf("bad created_at %q", tw.CreatedRaw)
+	}
+	return time.Time{}, err
+}
+
+// tweetURL returns the web address of tweet id, posted by account.
+func tweetURL(account string, id int64) string {
+	return fmt.Sprintf("https://twitter.com/%s/status/%d", account, id)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+func TestFromTwitterMedia(t *testing.T) {
+	const photoTweet = `{
+		"id": 10,
+		"created_at": "Fri Mar 04 15:30:00 +0000 2022",
+		"full_text": "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1",
+		"entities": {"media": [{"id": 99, "type": "photo", "media_url_https": "https://pbs.twimg.com/media/a.jpg"}]},
+		"extended_entities": {"media": [{"id": 99, "type": "photo", "media_url_https": "https://pbs.twimg.com/media/a.jpg"}]}
+	}`
+	const plainTweet = `{
+		"id": 11,
+		"created_at": "Fri Mar 04 15:31:00 +0000 2022",
+		"full_text": "22-2\n2 MAIN ST  DARTMOUTH\nMEDICAL\nE1"
+	}`
+	var tweets []twitter.Tweet
+	for _, s := range []string{photoTweet, plainTweet} {
+		var tw twitter.Tweet
+		if err := json.Unmarshal([]byte(s), &tw); err != nil {
+			t.Fatal(err)
+		}
+		tweets = append(tweets, tw)
+	}
+
+	raws := fromTwitter(tweets)
+	want := []rawMedia{{ID: 99, Type: "photo", URL: "https://pbs.twimg.com/media/a.jpg"}}
+	if len(raws[0].Media) != 1 || raws[0].Media[0] != want[0] {
+		t.Errorf("photo tweet media = %+v, want %+v", raws[0].Media, want)
+	}
+	if len(raws[1].Media) != 0 {
+		t.Errorf("plain tweet media = %+v, want none", raws[1].Media)
+	}
+
+	db := newTestDB(t)
+	im := &importer{db: db, quiet: true}
+	if err := im.process(raws); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "incidents"); n != 2 {
+		t.Fatalf("got %d incidents, want 2", n)
+	}
+	var (
+		incidentID, typ, url string
+		tweetID, mediaID     int64
+	)
+	if err := db.QueryRow("select incident_id, tweet_id, media_id, type, url from incident_media").Scan(&incidentID, &tweetID, &mediaID, &typ, &url); err != nil {
+		t.Fatal(err)
+	}
+	if incidentID != "22-1" || tweetID != 10 || mediaID != 99 || typ != "photo" || url != want[0].URL {
+		t.Errorf("got media %v %v %v %v %v", incidentID, tweetID, mediaID, typ, url)
+	}
+	if n := countRows(t, db, "incident_media"); n != 1 {
+		t.Errorf("got %d media rows, want 1", n)
+	}
+}
+
+func TestFromTwitter(t *testing.T) {
+	tweets := []twitter.Tweet{
+		{
+			ID:        10,
+			FullText:  "22-1\n1 MAIN ST  DARTMOUTH\nFIRE\nE1",
+			CreatedAt: "Fri Mar 04 15:30:00 +0000 2022",
+			Lang:      "en",
+			Source:    "TweetDeck",
+			User:      &twitter.User{StatusesCount: 1234},
+		},
+		{ID: 11, FullText: "22-2", CreatedAt: "yesterday"},
+	}
+	raws := fromTwitter(tweets)
+	if len(raws) != 2 {
+		t.Fatalf("got %d tweets, want 2", len(raws))
+	}
+
+	got := raws[0]
+	if got.ID != 10 || got.Text != tweets[0].FullText || got.CreatedRaw != tweets[0].CreatedAt ||
+		got.Lang != "en" || got.Source != "TweetDeck" || got.AuthorTweetCount != 1234 {
+		t.Errorf("got %+v", got)
+	}
+	if created, err := got.createdAt(); err != nil || !created.Equal(testTime) {
+		t.Errorf("createdAt = %v, %v, want %v", created, err, testTime)
+	}
+
+	// A bad created_at fails as the library's own parsing does.
+	_, wantErr := tweets[1].CreatedAtTime()
+	if _, err := raws[1].createdAt(); err == nil || err.Error() != wantErr.Error() {
+		t.Errorf("createdAt error = %v, want %v", err, wantErr)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+	_ "time/tzdata" // so -tz works where the system has no zoneinfo
+)
+
+// defaultTZ is where HRFE operates.
+const defaultTZ = "America/Halifax"
+
+// feedLoc is the time zone of times written in tweets.
+var feedLoc = mustLoadLocation(defaultTZ)
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// inputLoc is the time zone of timestamps without one from sources other
+// than the Twitter API, set with -tz-in.
+var inputLoc = feedLoc
+
+// displayLoc is the time zone for all human-facing output, set with -tz.
+// Stored timestamps are always UTC.
+var displayLoc = time.UTC
+
+func setDisplayTZ(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	displayLoc = loc
+	return nil
+}
+
+func setInputTZ(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	inputLoc = loc
+	return nil
+}
+
+// local converts t to the display time zone.
+func local(t time.Time) time.Time {
+	return t.In(displayLoc)
+}
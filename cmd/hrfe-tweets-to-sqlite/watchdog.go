@@ -0,0 +1,44 @@
+package main
+
+import "database/sql"
+
+// recordRun notes the newest stored tweet ID at the end of a fetch in the
+// runs table. Only the last keep+1 runs are kept, enough for staleRuns to
+// count up to keep.
+func recordRun(db *sql.DB, maxID int64, keep int) error {
+	if _, err := db.Exec("insert into runs values (datetime('now'), ?)", maxID); err != nil {
+		return err
+	}
+	_, err := db.Exec("delete from runs where rowid not in (select rowid from runs order by rowid desc limit ?)", keep+1)
+	return err
+}
+
+// staleRuns returns how many of the most recent recorded runs in a row
+// found no tweet newer than the run before them.
+func staleRuns(db *sql.DB) (int, error) {
+	rows, err := db.Query("select max_tweet_id from runs order by rowid desc")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var (
+		n      int
+		newest int64
+	)
+	for i := 0; rows.Next(); i++ {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		if i == 0 {
+			newest = id
+			continue
+		}
+		if id != newest {
+			break
+		}
+		n++
+	}
+	return n, rows.Err()
+}
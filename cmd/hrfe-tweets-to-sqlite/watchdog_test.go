@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestStaleRuns(t *testing.T) {
+	db := newTestDB(t)
+	if n, err := staleRuns(db); err != nil || n != 0 {
+		t.Fatalf("no runs: got %v, %v, want 0", n, err)
+	}
+
+	const keep = 3
+	for i, tt := range []struct {
+		maxID int64
+		want  int
+	}{
+		{10, 0}, // first run, nothing to compare with
+		{20, 0},
+		{20, 1},
+		{20, 2},
+		{20, 3},
+		{20, 3}, // only keep runs are remembered
+		{30, 0},
+		{30, 1},
+	} {
+		if err := recordRun(db, tt.maxID, keep); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := staleRuns(db); err != nil || n != tt.want {
+			t.Errorf("run %d with max %d: got %v, %v, want %d", i+1, tt.maxID, n, err, tt.want)
+		}
+	}
+	if n := countRows(t, db, "runs"); n != keep+1 {
+		t.Errorf("kept %d runs, want %d", n, keep+1)
+	}
+}